@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnsrpz"
+)
+
+// createPolicyEngine loads the response policies. Policies listed first take precedence.
+func createPolicyEngine(config *Config) (*dnsrpz.PolicyEngine, error) {
+	sources, err := buildPolicySources(config)
+	if err != nil {
+		return nil, err
+	}
+	engine := dnsrpz.NewPolicyEngine(sources)
+	if err := engine.Startup(); err != nil {
+		return nil, err
+	}
+	for _, v := range sources {
+		logger.Info("dns policy loaded. type:", v.Type, "location:", v.Location)
+	}
+	return engine, nil
+}
+
+func buildPolicySources(config *Config) ([]*dnsrpz.Source, error) {
+	var sources []*dnsrpz.Source
+	for _, v := range config.DnsPolicies {
+		if v.Location == "" {
+			return nil, errors.New("dns policy location is required")
+		}
+		source := &dnsrpz.Source{
+			Type:     v.Type,
+			Location: v.Location,
+		}
+		switch v.Type {
+		case dnsrpz.SourceTypeRpz:
+		case dnsrpz.SourceTypeDomains:
+			action, err := dnsrpz.ParseAction(v.Action)
+			if err != nil {
+				return nil, err
+			}
+			source.Action = action
+		default:
+			return nil, errors.New("unknown dns policy type:" + v.Type)
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
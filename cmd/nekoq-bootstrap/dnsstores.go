@@ -0,0 +1,116 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnsdyn"
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnszone"
+)
+
+type prioritizedDnsStore struct {
+	priority int
+	store    dnscore.DnsStorage
+}
+
+// createDnsStores starts all the nested dns stores of the storage.
+// Stores with smaller priority are queried first. Stores with the same priority keep the order in the configuration.
+func createDnsStores(config *Config) ([]dnscore.DnsStorage, map[string]func() any, error) {
+	var stores []prioritizedDnsStore
+	statusProviders := make(map[string]func() any)
+
+	// dynamic configure
+	if config.DnsDyn != nil {
+		logger.Info("DnsDyn enabled at servers:", config.DnsDyn.Servers, "selectors:", config.DnsDyn.Selectors, "group:", config.DnsDyn.Group, "keys:", config.DnsDyn.Keys)
+		store := dnsdyn.NewDnsDynConfStore(&dnsdyn.DnsDynConfStoreConfig{
+			Servers:   config.DnsDyn.Servers,
+			Selectors: config.DnsDyn.Selectors,
+			Group:     config.DnsDyn.Group,
+			Keys:      config.DnsDyn.Keys,
+		})
+		if err := store.Startup(); err != nil {
+			return nil, nil, err
+		}
+		stores = append(stores, prioritizedDnsStore{priority: config.DnsDyn.Priority, store: store})
+		statusProviders["dns_dyn"] = func() any {
+			return store.Status()
+		}
+	} else {
+		logger.Info("DnsDyn disabled.")
+	}
+
+	// zone files
+	if len(config.Dns.ZoneFiles) > 0 {
+		logger.Info("load dns records from zone files:", config.Dns.ZoneFiles)
+		store := dnszone.NewZoneFileStore(config.Dns.ZoneFiles)
+		if err := store.Startup(); err != nil {
+			return nil, nil, err
+		}
+		stores = append(stores, prioritizedDnsStore{priority: config.Dns.ZoneFilesPriority, store: store})
+	}
+
+	// file/url/dir sources
+	var sourceStatuses []func() dnsdyn.SourceStatus
+	for _, v := range config.DnsSources {
+		interval := time.Duration(v.Interval) * time.Second
+		logger.Info("load dns records from source:", v.Type, v.Location)
+		var store dnscore.DnsStorage
+		switch v.Type {
+		case "file":
+			s, err := dnsdyn.NewFileStore(v.Location, v.Format, interval)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := s.Startup(); err != nil {
+				return nil, nil, err
+			}
+			store = s
+			sourceStatuses = append(sourceStatuses, s.Status)
+		case "url":
+			s, err := dnsdyn.NewUrlStore(v.Location, v.Format, interval)
+			if err != nil {
+				return nil, nil, err
+			}
+			if v.MaxSize > 0 {
+				s.MaxSize = v.MaxSize
+			}
+			if err := s.Startup(); err != nil {
+				return nil, nil, err
+			}
+			store = s
+			sourceStatuses = append(sourceStatuses, s.Status)
+		case "dir":
+			s := dnsdyn.NewDirStore(v.Location, interval)
+			if err := s.Startup(); err != nil {
+				return nil, nil, err
+			}
+			store = s
+			sourceStatuses = append(sourceStatuses, s.Status)
+		default:
+			return nil, nil, errors.New(fmt.Sprint("unknown dns source type:", v.Type))
+		}
+		stores = append(stores, prioritizedDnsStore{priority: v.Priority, store: store})
+	}
+	if len(sourceStatuses) > 0 {
+		statusProviders["dns_sources"] = func() any {
+			var r []dnsdyn.SourceStatus
+			for _, f := range sourceStatuses {
+				r = append(r, f())
+			}
+			return r
+		}
+	}
+
+	slices.SortStableFunc(stores, func(a, b prioritizedDnsStore) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
+	var r []dnscore.DnsStorage
+	for _, v := range stores {
+		r = append(r, v.store)
+	}
+	return r, statusProviders, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+const dnstapVersion = "nekoq-bootstrap"
+
+func createDnstapWriter(config *Config) (*dnscore.DnstapWriter, error) {
+	c := config.Dnstap
+	identity := c.Identity
+	if identity == "" {
+		identity = config.Cluster.NodeName
+	}
+	writer, err := dnscore.NewDnstapWriter(c.Type, c.Location, identity, dnstapVersion, c.BufferSize)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("dnstap enabled. output:", c.Type, c.Location, "identity:", identity)
+	return writer, nil
+}
+
+func validateDnstapConfig(config *Config) error {
+	c := config.Dnstap
+	if !c.Enable {
+		return nil
+	}
+	switch c.Type {
+	case "unix", "file":
+	default:
+		return errors.New("unknown dnstap type:" + c.Type)
+	}
+	if c.Location == "" {
+		return errors.New("dnstap location is required")
+	}
+	return nil
+}
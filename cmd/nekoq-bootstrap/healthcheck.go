@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func buildHealthCheckConfigs(config *Config) ([]*dnscore.HealthCheckConfig, error) {
+	var configs []*dnscore.HealthCheckConfig
+	for _, v := range config.DnsHealthChecks {
+		domainType, ok := shared.ParseDomainType(v.Type)
+		if !ok {
+			return nil, errors.New("unknown health check record type:" + v.Type)
+		}
+		c := &dnscore.HealthCheckConfig{
+			Domain:      v.Domain,
+			Type:        domainType,
+			Check:       v.Check,
+			Port:        v.Port,
+			Path:        v.Path,
+			ProbeDomain: v.ProbeDomain,
+			Interval:    v.Interval,
+			Timeout:     v.Timeout,
+		}
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+func createHealthChecker(config *Config, storage dnscore.DnsStorage) (*dnscore.HealthChecker, error) {
+	configs, err := buildHealthCheckConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	return dnscore.NewHealthChecker(storage, configs)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+
+	bootstrap "github.com/meidoworks/nekoq-bootstrap"
+	"github.com/meidoworks/nekoq-bootstrap/internal/httpserver"
+	"github.com/meidoworks/nekoq-bootstrap/internal/service"
+)
+
+func validateKVConfig(config *Config) error {
+	kvConfig := config.Http.KV
+	if !kvConfig.Enable {
+		return nil
+	}
+	if kvConfig.Listener == "" || kvConfig.DataFolder == "" || kvConfig.WalFolder == "" {
+		return errors.New("http kv requires listener, data_folder and wal_folder")
+	}
+	return nil
+}
+
+// startKVStore serves the kv store authorized by the credentials of the http endpoint,
+// so the keys are isolated by the namespaces the credentials own
+func startKVStore(config *Config, httpEP *bootstrap.HttpEndpoint) error {
+	kvConfig := config.Http.KV
+	kv, err := service.NewKVStorageAdv(&service.KVStorageAdvConfig{
+		DataFolder: kvConfig.DataFolder,
+		WalFolder:  kvConfig.WalFolder,
+	})
+	if err != nil {
+		return err
+	}
+	if err := kv.Initialize(); err != nil {
+		return err
+	}
+	return service.NewHttpServiceContainer(httpserver.NewHttpServer(kvConfig.Listener)).
+		SetAuthorizer(httpEP.AuthorizeRequest).
+		SetupKVStorage(kv).
+		Startup()
+}
@@ -5,24 +5,29 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/google/gops/agent"
+	"github.com/miekg/dns"
 
 	bootstrap "github.com/meidoworks/nekoq-bootstrap"
 	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
-	"github.com/meidoworks/nekoq-bootstrap/internal/dnsdyn"
 	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
 	"github.com/meidoworks/nekoq-bootstrap/logging"
 )
 
 var logger = logging.Manager.GetLogger("main")
 
+const configFile = "bootstrap.toml"
+
 type Config struct {
 	Main struct {
 		StorageProvider string `toml:"storage_provider"`
 		Debug           bool   `toml:"debug"`
+		WatchConfig     bool   `toml:"watch_config"`
 	} `toml:"main"`
 	Cluster struct {
 		ClusterName   string            `toml:"cluster_name"`
@@ -30,12 +35,16 @@ type Config struct {
 		NodeName      string            `toml:"node_name"`
 		Listener      string            `toml:"listener"`
 		Nodes         map[string]string `toml:"nodes"`
+		// DomainTombstoneTTL in seconds
+		DomainTombstoneTTL int `toml:"domain_tombstone_ttl"`
 	} `toml:"cluster"`
 	Dns struct {
 		Enable             bool     `toml:"enable"`
 		Address            string   `toml:"listener"`
 		HttpAddress        string   `toml:"http_listener"`
 		UpstreamDnsServers []string `toml:"upstream_dns_servers"`
+		ZoneFiles          []string `toml:"zone_files"`
+		ZoneFilesPriority  int      `toml:"zone_files_priority"`
 		StaticRules        struct {
 			A   map[string]string `toml:"A"`
 			TXT map[string]string `toml:"TXT"`
@@ -44,13 +53,109 @@ type Config struct {
 		} `toml:"static_rule"`
 	} `toml:"dns"`
 	DnsDyn *struct {
-		Servers []string `toml:"servers"`
+		Servers   []string `toml:"servers"`
+		Selectors string   `toml:"selectors"`
+		Group     string   `toml:"group"`
+		Keys      []string `toml:"keys"`
+		Priority  int      `toml:"priority"`
 	} `toml:"dns_dyn"`
+	DnsSources []struct {
+		Type     string `toml:"type"`
+		Location string `toml:"location"`
+		Format   string `toml:"format"`
+		Priority int    `toml:"priority"`
+		Interval int    `toml:"interval"`
+		MaxSize  int64  `toml:"max_size"`
+	} `toml:"dns_sources"`
+	DnsAcl struct {
+		ManagedZones []string `toml:"managed_zones"`
+		Recursion    []string `toml:"recursion"`
+	} `toml:"dns_acl"`
+	DnsEcs struct {
+		Mode          string `toml:"mode"`
+		IPv4PrefixLen int    `toml:"ipv4_prefix_len"`
+		IPv6PrefixLen int    `toml:"ipv6_prefix_len"`
+	} `toml:"dns_ecs"`
+	Dns64 struct {
+		Enable bool   `toml:"enable"`
+		Prefix string `toml:"prefix"`
+	} `toml:"dns64"`
+	DnsRateLimit struct {
+		QueriesPerSecond      float64 `toml:"queries_per_second"`
+		Burst                 int     `toml:"burst"`
+		IPv4PrefixLen         int     `toml:"ipv4_prefix_len"`
+		IPv6PrefixLen         int     `toml:"ipv6_prefix_len"`
+		RrlResponsesPerSecond float64 `toml:"rrl_responses_per_second"`
+		RrlWindow             int     `toml:"rrl_window"`
+		RrlSlip               *int    `toml:"rrl_slip"`
+	} `toml:"dns_rate_limit"`
+	DnsPolicies []struct {
+		Type     string `toml:"type"`
+		Location string `toml:"location"`
+		Action   string `toml:"action"`
+	} `toml:"dns_policies"`
+	DnsHealthChecks []struct {
+		Domain      string `toml:"domain"`
+		Type        string `toml:"type"`
+		Check       string `toml:"check"`
+		Port        int    `toml:"port"`
+		Path        string `toml:"path"`
+		ProbeDomain string `toml:"probe_domain"`
+		Interval    int    `toml:"interval"`
+		Timeout     int    `toml:"timeout"`
+	} `toml:"dns_health_checks"`
+	QueryLog struct {
+		Enable         bool    `toml:"enable"`
+		Format         string  `toml:"format"`
+		SampleRate     float64 `toml:"sample_rate"`
+		PathPrefix     string  `toml:"path_prefix"`
+		MaxDays        int     `toml:"max_days"`
+		MaxFileSize    int     `toml:"max_file_size"`
+		MaxFilesPerDay int     `toml:"max_files_per_day"`
+	} `toml:"query_log"`
+	Dnstap struct {
+		Enable     bool   `toml:"enable"`
+		Type       string `toml:"type"`
+		Location   string `toml:"location"`
+		Identity   string `toml:"identity"`
+		BufferSize int    `toml:"buffer_size"`
+	} `toml:"dnstap"`
 	Http struct {
-		Listener       string `toml:"listener"`
-		EnableAuth     bool   `toml:"enable_auth"`
-		AccessPassword string `toml:"access_password"`
+		Listener             string   `toml:"listener"`
+		EnableAuth           bool     `toml:"enable_auth"`
+		AccessPassword       string   `toml:"access_password"`
+		ServiceTTLMin        int      `toml:"service_ttl_min"`
+		ServiceTTLMax        int      `toml:"service_ttl_max"`
+		ServiceCheckNetworks []string `toml:"service_check_networks"`
+		KV                   struct {
+			Enable     bool   `toml:"enable"`
+			Listener   string `toml:"listener"`
+			DataFolder string `toml:"data_folder"`
+			WalFolder  string `toml:"wal_folder"`
+		} `toml:"kv"`
+		Tokens []struct {
+			Name       string   `toml:"name"`
+			Sha256     string   `toml:"sha256"`
+			Roles      []string `toml:"roles"`
+			Namespaces []string `toml:"namespaces"`
+		} `toml:"tokens"`
+		TLS struct {
+			Enable       bool   `toml:"enable"`
+			CertFile     string `toml:"cert_file"`
+			KeyFile      string `toml:"key_file"`
+			ClientCAFile string `toml:"client_ca_file"`
+			ClientAuth   string `toml:"client_auth"`
+			Identities   []struct {
+				Name     string   `toml:"name"`
+				Services []string `toml:"services"`
+			} `toml:"identities"`
+		} `toml:"tls"`
 	} `toml:"http"`
+	Namespaces []struct {
+		Name           string   `toml:"name"`
+		AccessPassword string   `toml:"access_password"`
+		DnsSuffixes    []string `toml:"dns_suffixes"`
+	} `toml:"namespaces"`
 	UpstreamDns struct {
 		EnclosureDomains []struct {
 			Type   string `toml:"type"`
@@ -66,30 +171,29 @@ func main() {
 		panic(err)
 	}
 
-	config := new(Config)
-	m, err := toml.DecodeFile("bootstrap.toml", config)
+	config, err := loadConfig(configFile)
 	if err != nil {
 		panic(err)
 	}
-	var _ = m
+	if err := validateConfig(config); err != nil {
+		panic(err)
+	}
+	reloader := newConfigReloader(configFile, config)
 
-	var dnsStores []dnscore.DnsStorage
-	// dynamic configure
-	if config.DnsDyn != nil {
-		logger.Info("DnsDyn enabled at servers:", config.DnsDyn.Servers)
-		store := dnsdyn.NewDnsDynConfStore(config.DnsDyn.Servers)
-		if err := store.Startup(); err != nil {
-			panic(err)
-		}
-		dnsStores = append(dnsStores, store)
-	} else {
-		logger.Info("DnsDyn disabled.")
+	dnsStores, statusProviders, err := createDnsStores(config)
+	if err != nil {
+		panic(err)
 	}
 
 	var storage bootstrap.Storage
 	switch config.Main.StorageProvider {
 	case "mem":
-		storage = bootstrap.NewMemStore(dnsStores)
+		memStore := bootstrap.NewMemStore(config.Cluster.NodeName, dnsStores)
+		if config.Cluster.DomainTombstoneTTL > 0 {
+			memStore.DomainTombstoneTTL = time.Duration(config.Cluster.DomainTombstoneTTL) * time.Second
+		}
+		go memStore.RunDomainTombstoneGC()
+		storage = memStore
 	default:
 		panic(errors.New("unknown storage provider"))
 	}
@@ -120,28 +224,91 @@ func main() {
 		}()
 	}
 
+	reloader.storage = storage
+
 	// dns
 	if config.Dns.Enable {
-		for k, v := range config.Dns.StaticRules.A {
-			storage.PutDomain(k, v, shared.DomainTypeA)
+		rules, err := buildStaticRules(config)
+		if err != nil {
+			panic(err)
 		}
-		for k, v := range config.Dns.StaticRules.TXT {
-			storage.PutDomain(k, v, shared.DomainTypeTxt)
+		storage.ReplaceStaticRules(rules)
+		// create services
+		endpoint, err := dnscore.NewDnsEndpoint(config.Dns.Address, storage, config.Dns.UpstreamDnsServers, convertEnclosureDomainSuffix(config.UpstreamDns.EnclosureDomains), config.Main.Debug)
+		if err != nil {
+			panic(err)
 		}
-		for k, v := range config.Dns.StaticRules.SRV {
-			storage.PutDomain(k, v, shared.DomainTypeSrv)
+		reloader.dnsEndpoint = endpoint
+		queryLogger, err := createQueryLogger(config)
+		if err != nil {
+			panic(err)
 		}
-
-		// inject ptr and overwrite low priorities
-		for k, v := range config.Dns.StaticRules.PTR {
-			if err := dnscore.AddIpReverseDnsToStorage(storage, k, v); err != nil {
+		endpoint.QueryLogger = queryLogger
+		acl, err := buildAccessControl(config)
+		if err != nil {
+			panic(err)
+		}
+		endpoint.ACL = acl
+		endpoint.Ecs = buildEcsConfig(config)
+		if config.Dns64.Enable {
+			dns64, err := dnscore.NewDns64Config(config.Dns64.Prefix)
+			if err != nil {
 				panic(err)
 			}
+			endpoint.EnableDns64(dns64)
+			logger.Info("dns64 enabled. prefix:", dns64.Prefix.String())
 		}
-		// create services
-		endpoint, err := dnscore.NewDnsEndpoint(config.Dns.Address, storage, config.Dns.UpstreamDnsServers, convertEnclosureDomainSuffix(config.UpstreamDns.EnclosureDomains), config.Main.Debug)
-		if err != nil {
-			panic(err)
+		if c := config.DnsRateLimit; c.QueriesPerSecond > 0 || c.RrlResponsesPerSecond > 0 {
+			slip := dnscore.DefaultRrlSlip
+			if c.RrlSlip != nil {
+				slip = *c.RrlSlip
+			}
+			limiter := dnscore.NewRateLimiter(&dnscore.RateLimitConfig{
+				QueriesPerSecond:      c.QueriesPerSecond,
+				Burst:                 c.Burst,
+				IPv4PrefixLen:         c.IPv4PrefixLen,
+				IPv6PrefixLen:         c.IPv6PrefixLen,
+				RrlResponsesPerSecond: c.RrlResponsesPerSecond,
+				RrlWindow:             c.RrlWindow,
+				RrlSlip:               slip,
+			})
+			endpoint.RateLimiter = limiter
+			statusProviders["dns_rate_limit"] = func() any {
+				return limiter.Status()
+			}
+		}
+		if len(config.DnsPolicies) > 0 {
+			engine, err := createPolicyEngine(config)
+			if err != nil {
+				panic(err)
+			}
+			endpoint.Policy = engine
+			statusProviders["dns_policies"] = func() any {
+				return engine.Status()
+			}
+		}
+		if len(config.DnsHealthChecks) > 0 {
+			checker, err := createHealthChecker(config, storage)
+			if err != nil {
+				panic(err)
+			}
+			checker.ChangeListener = endpoint.Cache.Purge
+			checker.Start()
+			endpoint.EnableHealthCheck(checker)
+			statusProviders["dns_health_checks"] = func() any {
+				return checker.Status()
+			}
+		}
+		if config.Dnstap.Enable {
+			writer, err := createDnstapWriter(config)
+			if err != nil {
+				panic(err)
+			}
+			writer.Start()
+			endpoint.EnableDnstap(writer)
+			statusProviders["dnstap"] = func() any {
+				return writer.Status()
+			}
 		}
 		httpEndpoint, err := dnscore.NewHttpDns(config.Dns.HttpAddress, endpoint, config.Main.Debug)
 		if err != nil {
@@ -172,6 +339,42 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		reloader.httpEndpoint = httpEP
+		httpEP.MinServiceTTL, httpEP.MaxServiceTTL, err = serviceTTLBounds(config)
+		if err != nil {
+			panic(err)
+		}
+		httpEP.ServiceCheckNetworks, err = dnscore.ParseCIDRList(config.Http.ServiceCheckNetworks)
+		if err != nil {
+			panic(err)
+		}
+		if err := httpEP.UpdateNamespaces(buildNamespaces(config)); err != nil {
+			panic(err)
+		}
+		if err := httpEP.UpdateApiTokens(buildApiTokens(config)); err != nil {
+			panic(err)
+		}
+		if tlsConfig := config.Http.TLS; tlsConfig.Enable {
+			httpEP.TLSConfig, err = bootstrap.NewServerTLSConfig(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile, tlsConfig.ClientAuth)
+			if err != nil {
+				panic(err)
+			}
+		}
+		if err := httpEP.UpdateCertIdentities(buildCertIdentities(config)); err != nil {
+			panic(err)
+		}
+		if dnsEndpoint := reloader.dnsEndpoint; dnsEndpoint != nil {
+			// records changed through the api take effect immediately
+			httpEP.SetDomainChangeListener(dnsEndpoint.Cache.Purge)
+		}
+		for name, provider := range statusProviders {
+			httpEP.RegisterStatusProvider(name, provider)
+		}
+		if config.Http.KV.Enable {
+			if err := startKVStore(config, httpEP); err != nil {
+				panic(err)
+			}
+		}
 
 		//TODO deferred
 		go func() {
@@ -182,10 +385,57 @@ func main() {
 		}()
 	}
 
+	if config.Main.WatchConfig {
+		go reloader.WatchFile()
+	}
+
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-sigs
-	fmt.Println("signal received:", sig)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for {
+		sig := <-sigs
+		if sig == syscall.SIGHUP {
+			logger.Info("SIGHUP received, reloading configuration")
+			if err := reloader.Reload(); err != nil {
+				logger.Error("reload configuration failed:", err)
+			}
+			continue
+		}
+		fmt.Println("signal received:", sig)
+		break
+	}
+}
+
+func loadConfig(file string) (*Config, error) {
+	config := new(Config)
+	if _, err := toml.DecodeFile(file, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func buildStaticRules(config *Config) (map[shared.DomainType]map[string]string, error) {
+	rules := map[shared.DomainType]map[string]string{
+		shared.DomainTypeA:   {},
+		shared.DomainTypeTxt: {},
+		shared.DomainTypeSrv: {},
+		shared.DomainTypePtr: {},
+	}
+	for k, v := range config.Dns.StaticRules.A {
+		rules[shared.DomainTypeA][k] = v
+	}
+	for k, v := range config.Dns.StaticRules.TXT {
+		rules[shared.DomainTypeTxt][k] = v
+	}
+	for k, v := range config.Dns.StaticRules.SRV {
+		rules[shared.DomainTypeSrv][k] = v
+	}
+	for k, v := range config.Dns.StaticRules.PTR {
+		if !dnscore.IsValidIPAddress(k) {
+			return nil, errors.New("invalid IP address:" + k)
+		}
+		rules[shared.DomainTypePtr][dnscore.FromIPAddressToPtrFqdn(k)] = dns.Fqdn(strings.ToLower(v))
+	}
+	return rules, nil
 }
 
 func convertEnclosureDomainSuffix(input []struct {
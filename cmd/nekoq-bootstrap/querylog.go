@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/logging"
+)
+
+const (
+	queryLogFormatJson   = "json"
+	queryLogFormatDnstap = "dnstap"
+
+	defaultQueryLogPathPrefix     = "logs/querylog"
+	defaultQueryLogMaxDays        = 7
+	defaultQueryLogMaxFileSize    = 50 // MB
+	defaultQueryLogMaxFilesPerDay = 10
+)
+
+// createQueryLogger returns nil when query log is disabled
+func createQueryLogger(config *Config) (dnscore.QueryLogger, error) {
+	c := config.QueryLog
+	if !c.Enable {
+		return nil, nil
+	}
+	pathPrefix := c.PathPrefix
+	if pathPrefix == "" {
+		pathPrefix = defaultQueryLogPathPrefix
+	}
+	maxDays := c.MaxDays
+	if maxDays <= 0 {
+		maxDays = defaultQueryLogMaxDays
+	}
+	maxFileSize := c.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = defaultQueryLogMaxFileSize
+	}
+	maxFilesPerDay := c.MaxFilesPerDay
+	if maxFilesPerDay <= 0 {
+		maxFilesPerDay = defaultQueryLogMaxFilesPerDay
+	}
+	sampleRate := c.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	switch c.Format {
+	case "", queryLogFormatJson:
+		manager, err := logging.NewFileManager(pathPrefix, maxDays, maxFileSize*1024*1024, maxFilesPerDay)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("query log enabled. format:", queryLogFormatJson, "path prefix:", pathPrefix, "sample rate:", sampleRate)
+		return dnscore.NewJsonQueryLogger(manager.GetLogger("querylog"), sampleRate), nil
+	case queryLogFormatDnstap:
+		// frame stream files are not rotated, a new file is created on every start
+		file := pathPrefix + "." + time.Now().Format("20060102-150405") + ".dnstap"
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		writer, err := dnscore.NewDnstapWriter("file", file, config.Cluster.NodeName, dnstapVersion, 0)
+		if err != nil {
+			return nil, err
+		}
+		writer.Start()
+		logger.Info("query log enabled. format:", queryLogFormatDnstap, "file:", file, "sample rate:", sampleRate)
+		return dnscore.NewDnstapQueryLogger(writer, sampleRate), nil
+	default:
+		return nil, errors.New("unknown query log format:" + c.Format)
+	}
+}
+
+func validateQueryLogConfig(config *Config) error {
+	c := config.QueryLog
+	switch c.Format {
+	case "", queryLogFormatJson, queryLogFormatDnstap:
+	default:
+		return errors.New("unknown query log format:" + c.Format)
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return errors.New("query log sample_rate should be within [0,1]")
+	}
+	return nil
+}
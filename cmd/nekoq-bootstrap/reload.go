@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	bootstrap "github.com/meidoworks/nekoq-bootstrap"
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+const configWatchInterval = 5 * time.Second
+
+// configReloader re-reads the configuration file and applies the sections supporting hot reload.
+// Sections that still require restart are only reported.
+type configReloader struct {
+	file string
+
+	current *Config
+	modTime time.Time
+	lock    sync.Mutex
+
+	storage      bootstrap.Storage
+	dnsEndpoint  *dnscore.DnsEndpoint
+	httpEndpoint *bootstrap.HttpEndpoint
+}
+
+func newConfigReloader(file string, config *Config) *configReloader {
+	r := &configReloader{
+		file:    file,
+		current: config,
+	}
+	if info, err := os.Stat(file); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// WatchFile reloads the configuration once the modification time of the file changes
+func (r *configReloader) WatchFile() {
+	for {
+		time.Sleep(configWatchInterval)
+
+		info, err := os.Stat(r.file)
+		if err != nil {
+			logger.Error("watch configuration file failed:", err)
+			continue
+		}
+		if info.ModTime().Equal(r.modTime) {
+			continue
+		}
+		logger.Info("configuration file changed, reloading configuration")
+		if err := r.Reload(); err != nil {
+			logger.Error("reload configuration failed:", err)
+		}
+	}
+}
+
+func (r *configReloader) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if info, err := os.Stat(r.file); err == nil {
+		r.modTime = info.ModTime()
+	}
+	config, err := loadConfig(r.file)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	changed, restartRequired, err := r.apply(config)
+	if err != nil {
+		return err
+	}
+	logger.Info("configuration reloaded. changed sections:", changed, "restart required sections:", restartRequired)
+	return nil
+}
+
+// apply applies the hot reload sections of the validated configuration.
+// Sections are reported as changed only when they are applied to a running module.
+// r.current keeps the running values: sections failed to apply are retried by the following reloads,
+// and sections requiring restart are reported by every reload until restart.
+func (r *configReloader) apply(config *Config) (changed, restartRequired []string, err error) {
+	old := r.current
+	applied := *old
+
+	// sections supporting hot reload
+	if !reflect.DeepEqual(old.Dns.StaticRules, config.Dns.StaticRules) && r.dnsEndpoint != nil {
+		rules, err := buildStaticRules(config)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.storage.ReplaceStaticRules(rules)
+		r.dnsEndpoint.Cache.Purge()
+		applied.Dns.StaticRules = config.Dns.StaticRules
+		changed = append(changed, "dns.static_rule")
+	}
+	if (!reflect.DeepEqual(old.Dns.UpstreamDnsServers, config.Dns.UpstreamDnsServers) || !reflect.DeepEqual(old.UpstreamDns, config.UpstreamDns)) &&
+		r.dnsEndpoint != nil {
+		if err := r.dnsEndpoint.ReloadUpstream(config.Dns.UpstreamDnsServers, convertEnclosureDomainSuffix(config.UpstreamDns.EnclosureDomains)); err != nil {
+			logger.Warn("reload upstream failed:", err)
+			restartRequired = append(restartRequired, "dns.upstream_dns_servers")
+		} else {
+			applied.Dns.UpstreamDnsServers = config.Dns.UpstreamDnsServers
+			applied.UpstreamDns = config.UpstreamDns
+			changed = append(changed, "upstream_dns")
+		}
+	}
+	if r.httpEndpoint != nil {
+		if old.Http.EnableAuth != config.Http.EnableAuth || old.Http.AccessPassword != config.Http.AccessPassword {
+			r.httpEndpoint.UpdateAuth(config.Http.EnableAuth, config.Http.AccessPassword)
+			applied.Http.EnableAuth, applied.Http.AccessPassword = config.Http.EnableAuth, config.Http.AccessPassword
+			changed = append(changed, "http.auth")
+		}
+		if !reflect.DeepEqual(old.Http.TLS.Identities, config.Http.TLS.Identities) {
+			if err := r.httpEndpoint.UpdateCertIdentities(buildCertIdentities(config)); err != nil {
+				logger.Error("update certificate identities failed:", err)
+			} else {
+				applied.Http.TLS.Identities = config.Http.TLS.Identities
+				changed = append(changed, "http.tls.identities")
+			}
+		}
+		if !reflect.DeepEqual(old.Http.Tokens, config.Http.Tokens) {
+			if err := r.httpEndpoint.UpdateApiTokens(buildApiTokens(config)); err != nil {
+				logger.Error("update api tokens failed:", err)
+			} else {
+				applied.Http.Tokens = config.Http.Tokens
+				changed = append(changed, "http.tokens")
+			}
+		}
+		if !reflect.DeepEqual(old.Namespaces, config.Namespaces) {
+			if err := r.httpEndpoint.UpdateNamespaces(buildNamespaces(config)); err != nil {
+				logger.Error("update namespaces failed:", err)
+			} else {
+				applied.Namespaces = config.Namespaces
+				changed = append(changed, "namespaces")
+			}
+		}
+	}
+
+	// sections requiring restart
+	if !reflect.DeepEqual(old.Main, config.Main) {
+		restartRequired = append(restartRequired, "main")
+	}
+	if !reflect.DeepEqual(old.Cluster, config.Cluster) {
+		restartRequired = append(restartRequired, "cluster")
+	}
+	if old.Http.Listener != config.Http.Listener {
+		restartRequired = append(restartRequired, "http.listener")
+	}
+	if old.Http.ServiceTTLMin != config.Http.ServiceTTLMin || old.Http.ServiceTTLMax != config.Http.ServiceTTLMax {
+		restartRequired = append(restartRequired, "http.service_ttl")
+	}
+	if old.Http.KV != config.Http.KV {
+		restartRequired = append(restartRequired, "http.kv")
+	}
+	if !reflect.DeepEqual(old.Http.ServiceCheckNetworks, config.Http.ServiceCheckNetworks) {
+		restartRequired = append(restartRequired, "http.service_check_networks")
+	}
+	if oldTLS, newTLS := old.Http.TLS, config.Http.TLS; oldTLS.Enable != newTLS.Enable || oldTLS.CertFile != newTLS.CertFile ||
+		oldTLS.KeyFile != newTLS.KeyFile || oldTLS.ClientCAFile != newTLS.ClientCAFile || oldTLS.ClientAuth != newTLS.ClientAuth {
+		restartRequired = append(restartRequired, "http.tls")
+	}
+	if old.Dns.Enable != config.Dns.Enable || old.Dns.Address != config.Dns.Address || old.Dns.HttpAddress != config.Dns.HttpAddress ||
+		!reflect.DeepEqual(old.Dns.ZoneFiles, config.Dns.ZoneFiles) || old.Dns.ZoneFilesPriority != config.Dns.ZoneFilesPriority {
+		restartRequired = append(restartRequired, "dns")
+	}
+	if !reflect.DeepEqual(old.DnsDyn, config.DnsDyn) {
+		restartRequired = append(restartRequired, "dns_dyn")
+	}
+	if !reflect.DeepEqual(old.DnsSources, config.DnsSources) {
+		restartRequired = append(restartRequired, "dns_sources")
+	}
+	if !reflect.DeepEqual(old.QueryLog, config.QueryLog) {
+		restartRequired = append(restartRequired, "query_log")
+	}
+	if !reflect.DeepEqual(old.Dnstap, config.Dnstap) {
+		restartRequired = append(restartRequired, "dnstap")
+	}
+	if !reflect.DeepEqual(old.DnsAcl, config.DnsAcl) {
+		restartRequired = append(restartRequired, "dns_acl")
+	}
+	if !reflect.DeepEqual(old.DnsEcs, config.DnsEcs) {
+		restartRequired = append(restartRequired, "dns_ecs")
+	}
+	if !reflect.DeepEqual(old.Dns64, config.Dns64) {
+		restartRequired = append(restartRequired, "dns64")
+	}
+	if !reflect.DeepEqual(old.DnsRateLimit, config.DnsRateLimit) {
+		restartRequired = append(restartRequired, "dns_rate_limit")
+	}
+	if !reflect.DeepEqual(old.DnsHealthChecks, config.DnsHealthChecks) {
+		restartRequired = append(restartRequired, "dns_health_checks")
+	}
+	if !reflect.DeepEqual(old.DnsPolicies, config.DnsPolicies) {
+		restartRequired = append(restartRequired, "dns_policies")
+	}
+
+	r.current = &applied
+	return changed, restartRequired, nil
+}
+
+func validateConfig(config *Config) error {
+	switch config.Main.StorageProvider {
+	case "mem":
+	default:
+		return errors.New("unknown storage provider")
+	}
+	if config.Cluster.DomainTombstoneTTL < 0 {
+		return errors.New("negative cluster domain_tombstone_ttl")
+	}
+	if err := validateListener(config.Http.Listener); err != nil {
+		return errors.New("invalid http listener:" + err.Error())
+	}
+	if _, _, err := serviceTTLBounds(config); err != nil {
+		return err
+	}
+	if _, err := dnscore.ParseCIDRList(config.Http.ServiceCheckNetworks); err != nil {
+		return errors.New("invalid http service_check_networks:" + err.Error())
+	}
+	if _, err := bootstrap.BuildNamespaces(buildNamespaces(config)); err != nil {
+		return err
+	}
+	if err := bootstrap.ValidateApiTokens(buildApiTokens(config)); err != nil {
+		return err
+	}
+	if err := validateHttpTLS(config); err != nil {
+		return err
+	}
+	namespaces := map[string]bool{bootstrap.DefaultNamespace: true}
+	for _, v := range config.Namespaces {
+		namespaces[v.Name] = true
+	}
+	for _, token := range config.Http.Tokens {
+		for _, namespace := range token.Namespaces {
+			if !namespaces[namespace] {
+				return errors.New("unknown namespace of api token " + token.Name + ":" + namespace)
+			}
+		}
+	}
+	for _, v := range config.Dns.UpstreamDnsServers {
+		if v == "" {
+			return errors.New("empty upstream dns server")
+		}
+	}
+	for k, v := range config.Dns.StaticRules.SRV {
+		if err := json.Unmarshal([]byte(v), new(dnscore.SrvRecordValue)); err != nil {
+			return errors.New("invalid SRV static rule:" + k)
+		}
+	}
+	if _, err := buildStaticRules(config); err != nil {
+		return err
+	}
+	if err := validateKVConfig(config); err != nil {
+		return err
+	}
+	if err := validateQueryLogConfig(config); err != nil {
+		return err
+	}
+	if err := validateDnstapConfig(config); err != nil {
+		return err
+	}
+	if _, err := buildPolicySources(config); err != nil {
+		return err
+	}
+	if _, err := createHealthChecker(config, nil); err != nil {
+		return err
+	}
+	if _, err := buildAccessControl(config); err != nil {
+		return err
+	}
+	if config.Dns64.Enable {
+		if _, err := dnscore.NewDns64Config(config.Dns64.Prefix); err != nil {
+			return err
+		}
+	}
+	if ecs := buildEcsConfig(config); ecs != nil {
+		if err := ecs.Validate(); err != nil {
+			return err
+		}
+	}
+	if c := config.DnsRateLimit; c.QueriesPerSecond < 0 || c.RrlResponsesPerSecond < 0 || (c.RrlSlip != nil && *c.RrlSlip < 0) {
+		return errors.New("negative dns rate limit settings")
+	}
+	return nil
+}
+
+// validateListener checks the listener the same way as the http endpoint parses it, e.g. tcp://0.0.0.0:18080
+func validateListener(listener string) error {
+	u, err := url.Parse(listener)
+	if err != nil {
+		return err
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return err
+	}
+	if host == "" || port == "" {
+		return errors.New("host and port are required:" + listener)
+	}
+	return nil
+}
+
+func buildNamespaces(config *Config) []*bootstrap.Namespace {
+	var namespaces []*bootstrap.Namespace
+	for _, v := range config.Namespaces {
+		namespaces = append(namespaces, &bootstrap.Namespace{
+			Name:           v.Name,
+			AccessPassword: v.AccessPassword,
+			DnsSuffixes:    v.DnsSuffixes,
+		})
+	}
+	return namespaces
+}
+
+func buildApiTokens(config *Config) []*bootstrap.ApiToken {
+	var tokens []*bootstrap.ApiToken
+	for _, v := range config.Http.Tokens {
+		tokens = append(tokens, &bootstrap.ApiToken{
+			Name:       v.Name,
+			Sha256:     v.Sha256,
+			Roles:      v.Roles,
+			Namespaces: v.Namespaces,
+		})
+	}
+	return tokens
+}
+
+func buildCertIdentities(config *Config) []*bootstrap.CertIdentity {
+	var identities []*bootstrap.CertIdentity
+	for _, v := range config.Http.TLS.Identities {
+		identities = append(identities, &bootstrap.CertIdentity{
+			Name:     v.Name,
+			Services: v.Services,
+		})
+	}
+	return identities
+}
+
+func validateHttpTLS(config *Config) error {
+	tlsConfig := config.Http.TLS
+	if tlsConfig.Enable && (tlsConfig.CertFile == "" || tlsConfig.KeyFile == "") {
+		return errors.New("http tls requires cert_file and key_file")
+	}
+	switch tlsConfig.ClientAuth {
+	case "", bootstrap.ClientAuthRequire, bootstrap.ClientAuthVerifyIfGiven:
+	default:
+		return errors.New("unknown http tls client_auth:" + tlsConfig.ClientAuth)
+	}
+	if len(tlsConfig.Identities) > 0 && (!tlsConfig.Enable || tlsConfig.ClientCAFile == "") {
+		return errors.New("http tls identities require tls enabled with client_ca_file")
+	}
+	return bootstrap.ValidateCertIdentities(buildCertIdentities(config))
+}
+
+// serviceTTLBounds returns the bounds of the registration ttl, omitted values are defaults
+func serviceTTLBounds(config *Config) (time.Duration, time.Duration, error) {
+	minTTL, maxTTL := bootstrap.DefaultMinServiceTTL, bootstrap.DefaultMaxServiceTTL
+	if config.Http.ServiceTTLMin > 0 {
+		minTTL = time.Duration(config.Http.ServiceTTLMin) * time.Second
+	}
+	if config.Http.ServiceTTLMax > 0 {
+		maxTTL = time.Duration(config.Http.ServiceTTLMax) * time.Second
+	}
+	if config.Http.ServiceTTLMin < 0 || config.Http.ServiceTTLMax < 0 || minTTL > maxTTL {
+		return 0, 0, errors.New("invalid service ttl bounds")
+	}
+	return minTTL, maxTTL, nil
+}
+
+// buildAccessControl returns nil when no acl is configured. An omitted list allows all the clients.
+func buildAccessControl(config *Config) (*dnscore.AccessControl, error) {
+	if len(config.DnsAcl.ManagedZones) == 0 && len(config.DnsAcl.Recursion) == 0 {
+		return nil, nil
+	}
+	acl := new(dnscore.AccessControl)
+	if len(config.DnsAcl.ManagedZones) > 0 {
+		list, err := dnscore.ParseCIDRList(config.DnsAcl.ManagedZones)
+		if err != nil {
+			return nil, err
+		}
+		acl.ManagedZones = list
+	}
+	if len(config.DnsAcl.Recursion) > 0 {
+		list, err := dnscore.ParseCIDRList(config.DnsAcl.Recursion)
+		if err != nil {
+			return nil, err
+		}
+		acl.Recursion = list
+	}
+	return acl, nil
+}
+
+// buildEcsConfig returns nil when the client subnet option is passed through
+func buildEcsConfig(config *Config) *dnscore.EcsConfig {
+	if config.DnsEcs.Mode == "" || config.DnsEcs.Mode == dnscore.EcsModePassthrough {
+		return nil
+	}
+	return &dnscore.EcsConfig{
+		Mode:          config.DnsEcs.Mode,
+		IPv4PrefixLen: config.DnsEcs.IPv4PrefixLen,
+		IPv6PrefixLen: config.DnsEcs.IPv6PrefixLen,
+	}
+}
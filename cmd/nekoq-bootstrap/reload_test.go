@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bootstrap "github.com/meidoworks/nekoq-bootstrap"
+)
+
+const testConfig = `
+[main]
+storage_provider="mem"
+
+[http]
+listener="tcp://0.0.0.0:18080"
+enable_auth=true
+access_password="pw"
+
+[dns.static_rule.A]
+"node1.example.dns"="127.0.0.1"
+`
+
+func writeTestConfig(t *testing.T, file, content string) *Config {
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestValidateListener(t *testing.T) {
+	if err := validateListener("tcp://0.0.0.0:18080"); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"tcp://0.0.0.0", "tcp://:18080", "tcp://0.0.0.0:", "0.0.0.0:18080", "%"} {
+		if err := validateListener(v); err == nil {
+			t.Fatal("invalid listener should be rejected:", v)
+		}
+	}
+}
+
+func TestConfigReloaderRejectsInvalidConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bootstrap.toml")
+	config := writeTestConfig(t, file, testConfig)
+	r := newConfigReloader(file, config)
+
+	writeTestConfig(t, file, `
+[main]
+storage_provider="mem"
+
+[http]
+listener="tcp://0.0.0.0"
+`)
+	if err := r.Reload(); err == nil {
+		t.Fatal("invalid listener should be rejected")
+	}
+	if r.current != config {
+		t.Fatal("previous configuration should be kept")
+	}
+}
+
+func TestConfigReloaderChangedSections(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bootstrap.toml")
+	config := writeTestConfig(t, file, testConfig)
+	storage := bootstrap.NewMemStore("node1", nil)
+	httpEP, err := bootstrap.NewHttpEndpoint(config.Http.Listener, storage, true, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newConfigReloader(file, config)
+	r.storage = storage
+	r.httpEndpoint = httpEP
+
+	// the dns module is disabled, so static rules and upstreams are not applied
+	changed, restartRequired, err := r.apply(writeTestConfig(t, file, `
+[main]
+storage_provider="mem"
+
+[http]
+listener="tcp://0.0.0.0:18090"
+enable_auth=true
+access_password="pw2"
+
+[dns]
+upstream_dns_servers=["8.8.8.8:53"]
+
+[dns.static_rule.A]
+"node1.example.dns"="127.0.0.2"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"http.auth"}) {
+		t.Fatal("unexpected changed sections:", changed)
+	}
+	if !reflect.DeepEqual(restartRequired, []string{"http.listener"}) {
+		t.Fatal("unexpected restart required sections:", restartRequired)
+	}
+	if httpEP.AccessPassword != "pw2" {
+		t.Fatal("access password is not applied")
+	}
+}
+
+func TestConfigReloaderRetriesFailedSections(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bootstrap.toml")
+	config := writeTestConfig(t, file, testConfig)
+	storage := bootstrap.NewMemStore("node1", nil)
+	httpEP, err := bootstrap.NewHttpEndpoint(config.Http.Listener, storage, true, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newConfigReloader(file, config)
+	r.storage = storage
+	r.httpEndpoint = httpEP
+
+	const reloaded = `
+[main]
+storage_provider="mem"
+
+[http]
+listener="tcp://0.0.0.0:18090"
+enable_auth=true
+access_password="pw2"
+
+[[http.tokens]]
+name="ops"
+sha256="%s"
+roles=["admin"]
+`
+	// the invalid token is not applied while the other sections are
+	changed, restartRequired, err := r.apply(writeTestConfig(t, file, fmt.Sprintf(reloaded, "xyz")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"http.auth"}) || !reflect.DeepEqual(restartRequired, []string{"http.listener"}) {
+		t.Fatal("unexpected sections:", changed, restartRequired)
+	}
+	if len(r.current.Http.Tokens) != 0 || r.current.Http.AccessPassword != "pw2" {
+		t.Fatal("only the applied sections should be current")
+	}
+
+	// the following reload retries the tokens, and still reports the listener requiring restart
+	changed, restartRequired, err = r.apply(writeTestConfig(t, file, fmt.Sprintf(reloaded, bootstrap.HashApiToken("ops-token"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(changed, []string{"http.tokens"}) || !reflect.DeepEqual(restartRequired, []string{"http.listener"}) {
+		t.Fatal("unexpected sections:", changed, restartRequired)
+	}
+	if len(r.current.Http.Tokens) != 1 || r.current.Http.Listener != config.Http.Listener {
+		t.Fatal("unexpected current configuration:", r.current.Http)
+	}
+}
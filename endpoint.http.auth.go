@@ -0,0 +1,200 @@
+package bootstrap
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+)
+
+var apiTokenRoles = []string{iface.RoleDiscovery, iface.RoleRegister, iface.RoleAdmin, iface.RoleKvRead, iface.RoleKvWrite}
+
+// ApiToken is a named credential sent as "Authorization: Bearer <token>".
+// Only the digest of the token is configured so that configuration files don't leak tokens.
+type ApiToken struct {
+	Name string
+	// Sha256 is the hex encoded sha256 digest of the token, see HashApiToken
+	Sha256 string
+	// Roles granted to the token. RoleAdmin grants all the roles.
+	Roles []string
+	// Namespaces accessible with the token. All the namespaces are accessible when it is empty.
+	Namespaces []string
+}
+
+func (t *ApiToken) Validate() error {
+	if t.Name == "" {
+		return errors.New("api token name is required")
+	}
+	if digest, err := hex.DecodeString(t.Sha256); err != nil || len(digest) != sha256.Size {
+		return errors.New("invalid sha256 of api token:" + t.Name)
+	}
+	if len(t.Roles) == 0 {
+		return errors.New("api token requires roles:" + t.Name)
+	}
+	for _, role := range t.Roles {
+		if !slices.Contains(apiTokenRoles, role) {
+			return errors.New("unknown role of api token " + t.Name + ":" + role)
+		}
+	}
+	return nil
+}
+
+func (t *ApiToken) hasRole(role string) bool {
+	return slices.Contains(t.Roles, iface.RoleAdmin) || slices.Contains(t.Roles, role)
+}
+
+func (t *ApiToken) allowsNamespace(namespace string) bool {
+	return len(t.Namespaces) == 0 || slices.Contains(t.Namespaces, namespace)
+}
+
+// HashApiToken returns the digest of the token configured as ApiToken.Sha256,
+// the same as the output of `echo -n <token> | sha256sum`
+func HashApiToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// ValidateApiTokens checks the tokens and their names are unique
+func ValidateApiTokens(tokens []*ApiToken) error {
+	names := map[string]bool{}
+	for _, v := range tokens {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+		if names[v.Name] {
+			return errors.New("duplicated api token:" + v.Name)
+		}
+		names[v.Name] = true
+	}
+	return nil
+}
+
+// UpdateApiTokens replaces all the api tokens. It takes effect on the following requests.
+func (this *HttpEndpoint) UpdateApiTokens(tokens []*ApiToken) error {
+	if err := ValidateApiTokens(tokens); err != nil {
+		return err
+	}
+	var parsed []*ApiToken
+	for _, v := range tokens {
+		t := *v
+		t.Sha256 = strings.ToLower(t.Sha256)
+		parsed = append(parsed, &t)
+	}
+
+	this.authLock.Lock()
+	defer this.authLock.Unlock()
+	this.apiTokens = parsed
+	return nil
+}
+
+// secretEqual compares secrets in constant time. Digests are compared so that the length of secrets is not leaked.
+func secretEqual(a, b string) bool {
+	da := sha256.Sum256([]byte(a))
+	db := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(da[:], db[:]) == 1
+}
+
+// authenticate returns the identity of the credential of the request and whether it grants the role in the namespace.
+// Credentials are checked in order: api token, global access password and namespace access password.
+// The global access password grants all the roles while namespace access passwords grant all but admin.
+// this.authLock must be held.
+func (this *HttpEndpoint) authenticate(r *http.Request, namespace string, role string) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		digest := HashApiToken(token)
+		var matched *ApiToken
+		// all the tokens are compared to keep the time constant
+		for _, t := range this.apiTokens {
+			if subtle.ConstantTimeCompare([]byte(digest), []byte(t.Sha256)) == 1 {
+				matched = t
+			}
+		}
+		if matched == nil {
+			return "unknown-token", false
+		}
+		return "token:" + matched.Name, matched.hasRole(role) && matched.allowsNamespace(namespace)
+	}
+
+	password := r.Header.Get("X-Access-Password")
+	if password == "" {
+		return "anonymous", false
+	}
+	if this.AccessPassword != "" && secretEqual(password, this.AccessPassword) {
+		return "password", true
+	}
+	if ns, ok := this.namespaces[namespace]; ok && ns.AccessPassword != "" && secretEqual(password, ns.AccessPassword) {
+		return "namespace-password:" + namespace, role != iface.RoleAdmin
+	}
+	return "unknown-password", false
+}
+
+// checkNamespace returns the namespace of the request and http.StatusOK, or the status rejecting the request:
+// 404 for unknown namespaces, 401 for unknown credentials and 403 for credentials not granting the role.
+// Each authenticated request is logged with the identity of its credential for audit.
+// The namespace is read from the query string only, so the body is kept for the handlers, e.g. values of the kv store.
+func (this *HttpEndpoint) checkNamespace(r *http.Request, role string) (string, int) {
+	namespace := r.URL.Query().Get("namespace")
+
+	this.authLock.RLock()
+	defer this.authLock.RUnlock()
+	if _, ok := this.namespaces[namespace]; namespace != DefaultNamespace && !ok {
+		return "", http.StatusNotFound
+	}
+	if !this.EnableAuth {
+		return namespace, http.StatusOK
+	}
+	identity, allowed := this.authenticate(r, namespace, role)
+	log.Println("[AUDIT] identity:", identity, "role:", role, "namespace:", namespace, "allowed:", allowed,
+		"remote:", r.RemoteAddr, "request:", r.Method, r.URL.Path)
+	switch {
+	case allowed:
+		return namespace, http.StatusOK
+	case strings.HasPrefix(identity, "unknown") || identity == "anonymous":
+		return "", http.StatusUnauthorized
+	default:
+		return "", http.StatusForbidden
+	}
+}
+
+// authorize checks the namespace of the request and its credential for the role.
+// The response is written and false is returned when the request is rejected.
+func (this *HttpEndpoint) authorize(w http.ResponseWriter, r *http.Request, role string) (string, bool) {
+	namespace, status := this.checkNamespace(r, role)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		return "", false
+	}
+	return namespace, true
+}
+
+// authorizeApi checks the namespace of the request and its credential for the role. See checkNamespace.
+func (this *HttpEndpoint) authorizeApi(r *http.Request, role string) (string, error) {
+	namespace, status := this.checkNamespace(r, role)
+	switch status {
+	case http.StatusOK:
+		return namespace, nil
+	case http.StatusNotFound:
+		return "", newApiError(status, "unknown namespace:"+r.URL.Query().Get("namespace"))
+	case http.StatusForbidden:
+		return "", newApiError(status, "role is not granted:"+role)
+	default:
+		return "", newApiError(status, "invalid credential")
+	}
+}
+
+// AuthorizeRequest checks the credential of the request for the role, e.g. for the kv store.
+// It implements iface.HttpAuthorizer.
+func (this *HttpEndpoint) AuthorizeRequest(r *http.Request, role string) (string, iface.HttpResult) {
+	namespace, err := this.authorizeApi(r, role)
+	if err != nil {
+		var e *apiError
+		errors.As(err, &e)
+		return "", iface.JsonResult(e.Status, e)
+	}
+	return namespace, nil
+}
@@ -0,0 +1,107 @@
+package bootstrap
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+)
+
+func TestHttpEndpointApiToken(t *testing.T) {
+	if HashApiToken("abc") != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatal("unexpected digest")
+	}
+
+	ep := newTestEndpoint(t, nil, true, "pw")
+	if err := ep.UpdateNamespaces([]*Namespace{{Name: "team1", AccessPassword: "pw1"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tokens := range [][]*ApiToken{
+		{{Name: "a", Sha256: "xyz", Roles: []string{iface.RoleAdmin}}},
+		{{Name: "a", Sha256: HashApiToken("a"), Roles: []string{"root"}}},
+		{{Name: "a", Sha256: HashApiToken("a")}},
+		{{Name: "a", Sha256: HashApiToken("a"), Roles: []string{iface.RoleAdmin}}, {Name: "a", Sha256: HashApiToken("b"), Roles: []string{iface.RoleAdmin}}},
+	} {
+		if err := ep.UpdateApiTokens(tokens); err == nil {
+			t.Fatal("invalid tokens should be rejected:", tokens[0])
+		}
+	}
+	if err := ep.UpdateApiTokens([]*ApiToken{
+		{Name: "reader", Sha256: HashApiToken("reader-token"), Roles: []string{iface.RoleDiscovery}},
+		{Name: "team1-ci", Sha256: HashApiToken("team1-token"), Roles: []string{iface.RoleRegister, iface.RoleDiscovery}, Namespaces: []string{"team1"}},
+		{Name: "ops", Sha256: HashApiToken("ops-token"), Roles: []string{iface.RoleAdmin}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := func(method, path, token string) int {
+		return ep.doAs(method, path, "", "Authorization", "Bearer "+token).Code
+	}
+	for _, v := range []struct {
+		code   int
+		method string
+		path   string
+		header string
+		secret string
+	}{
+		{http.StatusUnauthorized, http.MethodGet, "/service?name=svc", "", ""},
+		{http.StatusUnauthorized, http.MethodGet, "/service?name=svc", "Authorization", "Bearer unknown"},
+		{http.StatusOK, http.MethodGet, "/service?name=svc", "Authorization", "Bearer reader-token"},
+		{http.StatusForbidden, http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1:80", "Authorization", "Bearer reader-token"},
+		{http.StatusForbidden, http.MethodGet, "/status", "Authorization", "Bearer reader-token"},
+		{http.StatusForbidden, http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1:80", "Authorization", "Bearer team1-token"},
+		{http.StatusOK, http.MethodPost, "/service?namespace=team1&name=svc&node=n1&address=10.0.0.1:80", "Authorization", "Bearer team1-token"},
+		{http.StatusOK, http.MethodGet, "/status", "Authorization", "Bearer ops-token"},
+		{http.StatusOK, http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1:80", "Authorization", "Bearer ops-token"},
+		// access passwords keep working
+		{http.StatusOK, http.MethodGet, "/status", "X-Access-Password", "pw"},
+		{http.StatusUnauthorized, http.MethodGet, "/status", "X-Access-Password", "wrong"},
+		{http.StatusOK, http.MethodGet, "/service?namespace=team1&name=svc", "X-Access-Password", "pw1"},
+		{http.StatusForbidden, http.MethodGet, "/status?namespace=team1", "X-Access-Password", "pw1"},
+	} {
+		if code := ep.doAs(v.method, v.path, "", v.header, v.secret).Code; code != v.code {
+			t.Fatal("unexpected status:", code, v)
+		}
+	}
+	if code := bearer(http.MethodGet, "/v1/services/svc", "reader-token"); code != http.StatusOK {
+		t.Fatal("unexpected status of v1 api:", code)
+	}
+	if code := bearer(http.MethodPut, "/v1/nodes/n1/heartbeat", "reader-token"); code != http.StatusForbidden {
+		t.Fatal("unexpected status of v1 api:", code)
+	}
+
+	// kv roles are checked by the authorizer of the kv store
+	req := httptest.NewRequest(http.MethodGet, "/services/kvstore/k", nil)
+	req.Header.Set("Authorization", "Bearer reader-token")
+	if _, r := ep.AuthorizeRequest(req, iface.RoleKvRead); r == nil {
+		t.Fatal("kv-read is not granted to the token")
+	}
+	req.Header.Set("Authorization", "Bearer ops-token")
+	if _, r := ep.AuthorizeRequest(req, iface.RoleKvWrite); r != nil {
+		t.Fatal("admin grants all the roles")
+	}
+	// the namespace of kv keys is owned by the credential
+	req = httptest.NewRequest(http.MethodPut, "/services/kvstore/k?namespace=team1", nil)
+	req.Header.Set("X-Access-Password", "pw1")
+	if namespace, r := ep.AuthorizeRequest(req, iface.RoleKvWrite); r != nil || namespace != "team1" {
+		t.Fatal("namespace of the password expected:", namespace)
+	}
+	req = httptest.NewRequest(http.MethodPut, "/services/kvstore/k", nil)
+	req.Header.Set("X-Access-Password", "pw1")
+	if _, r := ep.AuthorizeRequest(req, iface.RoleKvWrite); r == nil {
+		t.Fatal("namespace password should not access keys of the default namespace")
+	}
+	// the namespace is not read from the body, which is the value of the key
+	req = httptest.NewRequest(http.MethodPut, "/services/kvstore/k", strings.NewReader("namespace=team1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Access-Password", "pw1")
+	if _, r := ep.AuthorizeRequest(req, iface.RoleKvWrite); r == nil {
+		t.Fatal("namespace in the body should be ignored")
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != "namespace=team1" {
+		t.Fatal("body should be kept:", string(body))
+	}
+}
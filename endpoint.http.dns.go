@@ -0,0 +1,212 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const (
+	defaultDomainPageSize = 100
+	maxDomainPageSize     = 1000
+	maxDomainRequestSize  = 64 * 1024
+)
+
+type domainListResult struct {
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Records []*DomainRecord `json:"records"`
+}
+
+type domainPutRequest struct {
+	Value string `json:"value"`
+}
+
+// registerDnsRecordApi adds the record api under the prefix, e.g. /dns or /v1/dns.
+// Errors are rendered by the errorBody of the api version.
+func (this *HttpEndpoint) registerDnsRecordApi(router *httprouter.Router, prefix string, errorBody func(e *apiError) any) {
+	router.GET(prefix+"/records", this.handle(this.listDomains, errorBody))
+	router.GET(prefix+"/records/:type/:name", this.handle(this.getDomain, errorBody))
+	router.PUT(prefix+"/records/:type/:name", this.handle(this.putDomain, errorBody))
+	router.DELETE(prefix+"/records/:type/:name", this.handle(this.deleteDomain, errorBody))
+}
+
+// SetDomainChangeListener registers the callback invoked after records are changed through the api,
+// e.g. purging the dns cache
+func (this *HttpEndpoint) SetDomainChangeListener(listener func()) {
+	this.domainChangeListener = listener
+}
+
+func (this *HttpEndpoint) notifyDomainChanged() {
+	if this.domainChangeListener != nil {
+		this.domainChangeListener()
+	}
+}
+
+func (this *HttpEndpoint) listDomains(r *http.Request, _ httprouter.Params) (iface.HttpResult, error) {
+	namespace, err := this.authorizeApi(r, iface.RoleDiscovery)
+	if err != nil {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	suffix := strings.ToLower(query.Get("suffix"))
+	if suffix != "" {
+		suffix = dns.Fqdn(suffix)
+	}
+	var typeFilter string
+	if v := query.Get("type"); v != "" {
+		domainType, ok := shared.ParseDomainType(v)
+		if !ok {
+			return nil, newApiError(http.StatusBadRequest, "unknown record type:"+v)
+		}
+		typeFilter = domainType.String()
+	}
+	offset, err := parseIntParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		return nil, newApiError(http.StatusBadRequest, "invalid offset")
+	}
+	limit, err := parseIntParam(query.Get("limit"), defaultDomainPageSize)
+	if err != nil || limit <= 0 || limit > maxDomainPageSize {
+		return nil, newApiError(http.StatusBadRequest, "invalid limit")
+	}
+
+	records, err := this.Storage.ListDomains()
+	if err != nil {
+		return nil, err
+	}
+	filtered := make([]*DomainRecord, 0, len(records))
+	for _, v := range records {
+		if typeFilter != "" && v.Type != typeFilter {
+			continue
+		}
+		if suffix != "" && v.Domain != suffix && !strings.HasSuffix(v.Domain, "."+suffix) {
+			continue
+		}
+		if !this.ownsDomain(namespace, v.Domain) {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+
+	result := &domainListResult{
+		Total:   len(filtered),
+		Offset:  offset,
+		Limit:   limit,
+		Records: []*DomainRecord{},
+	}
+	if offset < len(filtered) {
+		result.Records = filtered[offset:min(offset+limit, len(filtered))]
+	}
+	return iface.JsonResult(http.StatusOK, result), nil
+}
+
+func (this *HttpEndpoint) getDomain(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	domainType, domain, err := this.authorizeDomain(r, params, iface.RoleDiscovery)
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := this.Storage.GetDomain(domain, domainType)
+	if errors.Is(err, shared.ErrStorageNotFound) {
+		return nil, newApiError(http.StatusNotFound, "record not found:"+domain)
+	} else if err != nil {
+		return nil, err
+	}
+	return iface.JsonResult(http.StatusOK, record), nil
+}
+
+func (this *HttpEndpoint) putDomain(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	domainType, domain, err := this.authorizeDomain(r, params, iface.RoleRegister)
+	if err != nil {
+		return nil, err
+	}
+	req := new(domainPutRequest)
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxDomainRequestSize)).Decode(req); err != nil {
+		return nil, newApiError(http.StatusBadRequest, "invalid request body:"+err.Error())
+	}
+	if domainType == shared.DomainTypePtr {
+		req.Value = dns.Fqdn(strings.ToLower(req.Value))
+	}
+	if err := dnscore.ValidateRecord(domainType, domain, req.Value); err != nil {
+		return nil, newApiError(http.StatusBadRequest, err.Error())
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] put domain:", domainType, domain, req.Value)
+	}
+
+	this.Storage.PutDomain(domain, req.Value, domainType)
+	this.notifyDomainChanged()
+
+	record, err := this.Storage.GetDomain(domain, domainType)
+	if err != nil {
+		return nil, err
+	}
+	return iface.JsonResult(http.StatusOK, record), nil
+}
+
+func (this *HttpEndpoint) deleteDomain(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	domainType, domain, err := this.authorizeDomain(r, params, iface.RoleRegister)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] delete domain:", domainType, domain)
+	}
+
+	err = this.Storage.DeleteDomain(domain, domainType)
+	if errors.Is(err, shared.ErrStorageNotFound) {
+		return nil, newApiError(http.StatusNotFound, "record not found:"+domain)
+	} else if err != nil {
+		return nil, err
+	}
+	this.notifyDomainChanged()
+
+	return iface.StatusOnlyResult(http.StatusNoContent), nil
+}
+
+// authorizeDomain parses the record of the path and checks that it is managed in the namespace of the request
+func (this *HttpEndpoint) authorizeDomain(r *http.Request, params httprouter.Params, role string) (shared.DomainType, string, error) {
+	namespace, err := this.authorizeApi(r, role)
+	if err != nil {
+		return 0, "", err
+	}
+	domainType, ok := shared.ParseDomainType(params.ByName("type"))
+	if !ok {
+		return 0, "", newApiError(http.StatusBadRequest, "unknown record type:"+params.ByName("type"))
+	}
+	domain := dns.Fqdn(strings.ToLower(params.ByName("name")))
+	if err := dnscore.ValidateDomainName(domain); err != nil {
+		return 0, "", newApiError(http.StatusBadRequest, err.Error())
+	}
+	if !this.ownsDomain(namespace, domain) {
+		return 0, "", newApiError(http.StatusForbidden, "domain is not managed in the namespace:"+domain)
+	}
+	return domainType, domain, nil
+}
+
+func parseIntParam(val string, defaultVal int) (int, error) {
+	if val == "" {
+		return defaultVal, nil
+	}
+	return strconv.Atoi(val)
+}
+
+// legacyErrorBody renders errors of the api without version as {"error": message}
+func legacyErrorBody(e *apiError) any {
+	return map[string]string{"error": e.Message}
+}
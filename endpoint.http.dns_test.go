@@ -0,0 +1,52 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestHttpEndpointDomainApi(t *testing.T) {
+	ep := newTestEndpoint(t, nil, true, "pw")
+	changed := 0
+	ep.SetDomainChangeListener(func() { changed++ })
+
+	if w := ep.do(http.MethodPut, "/dns/records/A/a.example.dns", `{"value":"10.0.0.1"}`); w.Code != http.StatusOK {
+		t.Fatal("put failed:", w.Code, w.Body.String())
+	}
+	if w := ep.do(http.MethodPut, "/dns/records/a/b.example.dns", `{"value":"10.0.0.2"}`); w.Code != http.StatusOK {
+		t.Fatal("put failed:", w.Code, w.Body.String())
+	}
+	if w := ep.do(http.MethodPut, "/dns/records/A/c.other.dns", `{"value":"::1"}`); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid record should be rejected:", w.Code)
+	}
+	if w := ep.do(http.MethodPut, "/dns/records/MX/c.other.dns", `{"value":"x"}`); w.Code != http.StatusBadRequest {
+		t.Fatal("unknown type should be rejected:", w.Code)
+	}
+
+	w := ep.do(http.MethodGet, "/dns/records?suffix=example.dns&limit=1&offset=1", "")
+	result := new(domainListResult)
+	if err := json.Unmarshal(w.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Records) != 1 || result.Records[0].Domain != "b.example.dns." {
+		t.Fatal("unexpected list result:", w.Body.String())
+	}
+
+	if w := ep.do(http.MethodDelete, "/dns/records/A/a.example.dns", ""); w.Code != http.StatusNoContent {
+		t.Fatal("delete failed:", w.Code)
+	}
+	if w := ep.do(http.MethodGet, "/dns/records/A/a.example.dns", ""); w.Code != http.StatusNotFound {
+		t.Fatal("deleted record should not be found:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/dns/records/A/a.example.dns", ""); w.Code != http.StatusNotFound {
+		t.Fatal("delete twice should not be found:", w.Code)
+	}
+	if changed != 3 {
+		t.Fatal("unexpected change notifications:", changed)
+	}
+
+	if w := ep.doAs(http.MethodGet, "/dns/records", "", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("request without password should be rejected:", w.Code)
+	}
+}
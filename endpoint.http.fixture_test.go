@@ -0,0 +1,70 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testEndpoint sends requests to the router of the http endpoint under test
+type testEndpoint struct {
+	*HttpEndpoint
+	t *testing.T
+	// password is sent in X-Access-Password by do
+	password string
+}
+
+// newTestEndpoint creates the endpoint on the store, or on a new store of node1 when store is nil
+func newTestEndpoint(t *testing.T, store *MemStore, enableAuth bool, accessPassword string) *testEndpoint {
+	t.Helper()
+	if store == nil {
+		store = NewMemStore("node1", nil)
+	}
+	ep, err := NewHttpEndpoint("http://127.0.0.1:0", store, enableAuth, accessPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEndpoint{HttpEndpoint: ep, t: t, password: accessPassword}
+}
+
+// as returns the endpoint sending requests with the access password, e.g. the password of a namespace
+func (e *testEndpoint) as(password string) *testEndpoint {
+	c := *e
+	c.password = password
+	return &c
+}
+
+// do sends the request with the access password of the endpoint
+func (e *testEndpoint) do(method, path, body string) *httptest.ResponseRecorder {
+	return e.doAs(method, path, body, "X-Access-Password", e.password)
+}
+
+// doAs sends the request with the credential in the header, e.g. Authorization: Bearer x.
+// No credential is sent when it is empty.
+func (e *testEndpoint) doAs(method, path, body, header, credential string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if credential != "" {
+		req.Header.Set(header, credential)
+	}
+	w := httptest.NewRecorder()
+	e.Router.ServeHTTP(w, req)
+	return w
+}
+
+// serviceItems decodes the service instances of a successful query
+func (e *testEndpoint) serviceItems(w *httptest.ResponseRecorder) []*ServiceItem {
+	e.t.Helper()
+	if w.Code != http.StatusOK {
+		e.t.Fatal("query failed:", w.Code, w.Body.String())
+	}
+	var items []*ServiceItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		e.t.Fatal(err)
+	}
+	return items
+}
@@ -1,14 +1,36 @@
 package bootstrap
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+)
+
+const (
+	// ServiceIndexHeader is the modification index of the service list in the response of GET /service
+	ServiceIndexHeader = "X-Service-Index"
+
+	DefaultServiceTTL    = 10 * time.Second
+	DefaultMinServiceTTL = 5 * time.Second
+	DefaultMaxServiceTTL = 5 * time.Minute
+
+	DefaultServiceWatchWait = 30 * time.Second
+	MaxServiceWatchWait     = 5 * time.Minute
 )
 
 type HttpEndpoint struct {
@@ -17,18 +39,44 @@ type HttpEndpoint struct {
 
 	EnableAuth     bool
 	AccessPassword string
+	namespaces     map[string]*Namespace
+	apiTokens      []*ApiToken
+	certIdentities []*CertIdentity
+	authLock       sync.RWMutex
 
 	Addr string
+	// TLSConfig enables https when it is not nil, e.g. verifying client certificates. See NewServerTLSConfig.
+	TLSConfig *tls.Config
 
 	DebugPrint bool
 
-	publicClients map[string]*struct {
-		LastUpdate  int64
-		Publishment map[string]struct {
-			Addr string
-		}
-	}
-	rwlock sync.RWMutex
+	statusProviders     map[string]func() any
+	statusProvidersLock sync.RWMutex
+
+	domainChangeListener func()
+
+	// bounds of the registration ttl requested by clients
+	MinServiceTTL time.Duration
+	MaxServiceTTL time.Duration
+	// ServiceCheckNetworks allows checks probing the addresses within the networks besides the registrant itself
+	ServiceCheckNetworks dnscore.CIDRList
+
+	publicClients map[clientKey]*publicClient
+	rwlock        sync.RWMutex
+}
+
+// publicClient is a node publishing services through this endpoint
+type publicClient struct {
+	Publishment map[string]*publishment
+}
+
+// publishment is a registration expired once it is not refreshed within TTL
+type publishment struct {
+	Instance   serviceInstance
+	TTL        time.Duration
+	LastUpdate time.Time
+	// Check is run by this endpoint periodically. It is nil when the registration declares no check.
+	Check *serviceCheck
 }
 
 func NewHttpEndpoint(addr string, storage Storage, enableAuth bool, accessPassword string) (*HttpEndpoint, error) {
@@ -46,85 +94,164 @@ func NewHttpEndpoint(addr string, storage Storage, enableAuth bool, accessPasswo
 	router := httprouter.New()
 	router.GET("/service", r.queryService)
 	router.POST("/service", r.publishService)
+	router.DELETE("/service", r.deleteService)
+	router.DELETE("/service/node", r.deregisterNode)
+	router.PUT("/service/heartbeat", r.heartbeat)
+	router.PUT("/service/status", r.updateServiceStatus)
+	router.PUT("/service/maintenance", r.updateMaintenance)
+	router.GET("/status", r.queryStatus)
+	r.registerDnsRecordApi(router, "/dns", legacyErrorBody)
+	r.registerV1Api(router)
 	r.Router = router
+	r.statusProviders = make(map[string]func() any)
 
-	r.publicClients = make(map[string]*struct {
-		LastUpdate  int64
-		Publishment map[string]struct {
-			Addr string
-		}
-	})
+	r.MinServiceTTL = DefaultMinServiceTTL
+	r.MaxServiceTTL = DefaultMaxServiceTTL
+	r.publicClients = make(map[clientKey]*publicClient)
+	r.namespaces = make(map[string]*Namespace)
 
 	return r, nil
 }
 
+// UpdateAuth replaces auth settings. It takes effect on the following requests.
+func (this *HttpEndpoint) UpdateAuth(enableAuth bool, accessPassword string) {
+	this.authLock.Lock()
+	defer this.authLock.Unlock()
+	this.EnableAuth = enableAuth
+	this.AccessPassword = accessPassword
+}
+
+// RegisterStatusProvider adds a component status to the result of GET /status
+func (this *HttpEndpoint) RegisterStatusProvider(name string, provider func() any) {
+	this.statusProvidersLock.Lock()
+	defer this.statusProvidersLock.Unlock()
+	this.statusProviders[name] = provider
+}
+
 func (this *HttpEndpoint) StartSync() error {
 	// cleanup timeout
 	go this.CheckPublishClients()
+	go this.RunServiceChecks()
 
-	return http.ListenAndServe(this.Addr, this.Router)
+	server := &http.Server{
+		Addr:      this.Addr,
+		Handler:   this.Router,
+		TLSConfig: this.TLSConfig,
+	}
+	if this.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
 }
 
 func (this *HttpEndpoint) CheckPublishClients() {
 	// cleanup timeout
 	for {
-		const interval = 10
 		time.Sleep(1 * time.Second)
+		this.expirePublishments(time.Now())
+	}
+}
 
-		f := func() {
-			this.rwlock.Lock()
-			defer this.rwlock.Unlock()
+// expirePublishments deletes the registrations not refreshed within their ttl
+func (this *HttpEndpoint) expirePublishments(now time.Time) {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
 
-			barrierTime := time.Now().UnixMilli() - interval*1000
-			var rlist []string
-			for k, v := range this.publicClients {
-				if v.LastUpdate < barrierTime {
-					rlist = append(rlist, k)
-				}
-			}
-			for _, vv := range rlist {
-				p := this.publicClients[vv]
-				for serviceName, v := range p.Publishment {
-					err := this.Storage.DeleteService(serviceName, &ServiceItem{
-						Addr:   v.Addr,
-						NodeId: vv,
-					})
-					if err != nil {
-						log.Println("[ERROR] cleanup service error:", err)
-					}
-				}
-				delete(this.publicClients, vv)
+	for client, p := range this.publicClients {
+		for serviceName, v := range p.Publishment {
+			if now.Sub(v.LastUpdate) > v.TTL {
+				this.removePublishment(client, serviceName)
 			}
 		}
-		f()
 	}
 }
 
+// removePublishment deletes the service of the node from storage, which propagates the deletion to peers.
+// false is returned when the node has no registration of the service. this.rwlock must be held.
+func (this *HttpEndpoint) removePublishment(client clientKey, serviceName string) bool {
+	p, ok := this.publicClients[client]
+	if !ok {
+		return false
+	}
+	v, ok := p.Publishment[serviceName]
+	if !ok {
+		return false
+	}
+	delete(p.Publishment, serviceName)
+	if len(p.Publishment) == 0 {
+		delete(this.publicClients, client)
+	}
+	err := this.Storage.DeleteService(serviceKey(client.Namespace, serviceName), &ServiceItem{
+		Addr:   v.Instance.Addr,
+		NodeId: client.NodeId,
+	})
+	if err != nil {
+		log.Println("[ERROR] cleanup service error:", err)
+	}
+	return true
+}
+
+// removeServices deletes the service of the node, or all the services of the node when serviceName is empty,
+// once the client certificate of the request permits them. false is returned when the service is not registered.
+func (this *HttpEndpoint) removeServices(r *http.Request, client clientKey, serviceName string) (bool, error) {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+	if serviceName == "" {
+		if err := this.permitServices(r, client.Namespace, this.publishedServices(client)...); err != nil {
+			return true, err
+		}
+		this.removePublicClient(client)
+		return true, nil
+	}
+	if err := this.permitServices(r, client.Namespace, serviceName); err != nil {
+		return true, err
+	}
+	return this.removePublishment(client, serviceName), nil
+}
+
+// removePublicClient deletes all the services published by the node. this.rwlock must be held.
+func (this *HttpEndpoint) removePublicClient(client clientKey) {
+	p, ok := this.publicClients[client]
+	if !ok {
+		return
+	}
+	for serviceName := range p.Publishment {
+		this.removePublishment(client, serviceName)
+	}
+	delete(this.publicClients, client)
+}
+
 func (this *HttpEndpoint) queryService(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	_ = r.ParseForm()
 	serviceName := r.FormValue("name")
 
-	if this.EnableAuth {
-		accessPassword := r.Header.Get("X-Access-Password")
-		if accessPassword != this.AccessPassword {
-			log.Println("[ERROR] access password doesn't match")
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+	namespace, ok := this.authorize(w, r, iface.RoleDiscovery)
+	if !ok {
+		return
+	}
+	if !isValidServiceName(serviceName) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	if this.DebugPrint {
-		log.Println("[DEBUG] query service:", serviceName)
+		log.Println("[DEBUG] query service:", namespace, serviceName)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	items, err := this.Storage.GetServiceList(serviceName)
+	query, err := parseServiceQuery(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	items, index, err := this.listServices(r.Context(), namespace, serviceName, query)
 	if err != nil {
 		log.Println("[ERROR] get service list error:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(ServiceIndexHeader, strconv.FormatUint(index, 10))
 
 	if len(items) == 0 {
 		items = []*ServiceItem{}
@@ -141,53 +268,341 @@ func (this *HttpEndpoint) queryService(w http.ResponseWriter, r *http.Request, _
 	}
 }
 
+// serviceQuery selects the instances of a service: repeated tag, all, index and wait
+type serviceQuery struct {
+	Tags []string
+	// All includes the instances not passing or in maintenance
+	All bool
+	// Watch blocks until the service changes after Index or Wait elapses
+	Watch bool
+	Index uint64
+	Wait  time.Duration
+}
+
+func parseServiceQuery(form url.Values) (*serviceQuery, error) {
+	query := &serviceQuery{
+		Tags: form["tag"],
+		All:  form.Get("all") == "true",
+	}
+	if v := form.Get("index"); v != "" {
+		index, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid index:" + v)
+		}
+		wait, err := parseWaitParam(form.Get("wait"))
+		if err != nil {
+			return nil, errors.New("invalid wait:" + form.Get("wait"))
+		}
+		query.Watch = true
+		query.Index = index
+		query.Wait = wait
+	}
+	return query, nil
+}
+
+// listServices returns the instances of the service in the namespace and the modification index of the service list
+func (this *HttpEndpoint) listServices(ctx context.Context, namespace, serviceName string, query *serviceQuery) ([]*ServiceItem, uint64, error) {
+	var items []*ServiceItem
+	var index uint64
+	var err error
+	if query.Watch {
+		// long-poll until the service changes after the index
+		ctx, cancel := context.WithTimeout(ctx, query.Wait)
+		items, index, err = this.Storage.WatchServiceList(ctx, serviceKey(namespace, serviceName), query.Index)
+		cancel()
+	} else {
+		items, index, err = this.Storage.GetServiceListWithIndex(serviceKey(namespace, serviceName))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// only passing instances are returned by default
+	items = slices.DeleteFunc(items, func(item *ServiceItem) bool {
+		return (!query.All && !item.Passing()) || !item.HasTags(query.Tags)
+	})
+	return items, index, nil
+}
+
 func (this *HttpEndpoint) publishService(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	_ = r.ParseForm()
 	serviceName := r.FormValue("name")
 	nodeId := r.FormValue("node")
 	address := r.FormValue("address")
 
-	if this.EnableAuth {
-		accessPassword := r.Header.Get("X-Access-Password")
-		if accessPassword != this.AccessPassword {
-			log.Println("[ERROR] access password doesn't match")
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	if !isValidServiceName(serviceName) {
+		log.Println("[ERROR] invalid service name:", serviceName)
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	if this.DebugPrint {
-		log.Println("[DEBUG] publish service:", serviceName, nodeId, address)
+		log.Println("[DEBUG] publish service:", namespace, serviceName, nodeId, address)
+	}
+
+	item, err := parseServiceItem(r.Form)
+	if err != nil {
+		log.Println("[ERROR] invalid service registration:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ttl, err := this.parseTTLParam(r.FormValue("ttl"))
+	if err != nil {
+		log.Println("[ERROR] invalid service registration:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	check, err := this.parseServiceCheck(r.Form, r.RemoteAddr)
+	if err != nil {
+		log.Println("[ERROR] invalid service registration:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := this.permitServices(r, namespace, serviceName); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	err := this.Storage.PublishService(serviceName, &ServiceItem{
-		Addr:   address,
-		NodeId: nodeId,
-	})
-	if err != nil {
+	if err := this.publish(client, serviceName, item, ttl, check); err != nil {
 		log.Println("[ERROR] publish service error:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// publish list
-	f := func() {
-		this.rwlock.Lock()
-		defer this.rwlock.Unlock()
-		p, ok := this.publicClients[nodeId]
-		if !ok {
-			p = &struct {
-				LastUpdate  int64
-				Publishment map[string]struct{ Addr string }
-			}{LastUpdate: time.Now().UnixMilli(), Publishment: map[string]struct{ Addr string }{}}
-			this.publicClients[nodeId] = p
+	w.WriteHeader(http.StatusOK)
+}
+
+// publish registers the service of the node, replacing the previous registration of the same service
+func (this *HttpEndpoint) publish(client clientKey, serviceName string, item *ServiceItem, ttl time.Duration, check *serviceCheck) error {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+	p, ok := this.publicClients[client]
+	if !ok {
+		p = &publicClient{Publishment: map[string]*publishment{}}
+		this.publicClients[client] = p
+	}
+	// maintenance mode and the state of the same check are kept when the instance publishes again
+	if prev, ok := p.Publishment[serviceName]; ok {
+		item.Maintenance = prev.Instance.Maintenance
+		if check != nil && check.sameAs(prev.Check) {
+			check = prev.Check
+			if check.critical {
+				item.Status = ServiceStatusCritical
+			}
+		}
+	}
+	if err := this.Storage.PublishService(serviceKey(client.Namespace, serviceName), item); err != nil {
+		if len(p.Publishment) == 0 {
+			delete(this.publicClients, client)
+		}
+		return err
+	}
+	p.Publishment[serviceName] = &publishment{
+		Instance:   item.instance(),
+		TTL:        ttl,
+		LastUpdate: time.Now(),
+		Check:      check,
+	}
+	return nil
+}
+
+// parseTTLParam parses the registration ttl, e.g. 30s, which must be within the bounds of the endpoint
+func (this *HttpEndpoint) parseTTLParam(v string) (time.Duration, error) {
+	if v == "" {
+		return min(max(DefaultServiceTTL, this.MinServiceTTL), this.MaxServiceTTL), nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if ttl < this.MinServiceTTL || ttl > this.MaxServiceTTL {
+		return 0, fmt.Errorf("ttl should be within [%s, %s]", this.MinServiceTTL, this.MaxServiceTTL)
+	}
+	return ttl, nil
+}
+
+// parseWaitParam parses the max blocking duration of watches, e.g. 30s
+func parseWaitParam(v string) (time.Duration, error) {
+	if v == "" {
+		return DefaultServiceWatchWait, nil
+	}
+	wait, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if wait <= 0 {
+		return DefaultServiceWatchWait, nil
+	}
+	return min(wait, MaxServiceWatchWait), nil
+}
+
+// parseServiceItem reads the registration from form values:
+// repeated tag=zone=a, repeated meta=key=value, weight, protocol, port and status
+func parseServiceItem(form url.Values) (*ServiceItem, error) {
+	item := &ServiceItem{
+		Addr:     form.Get("address"),
+		NodeId:   form.Get("node"),
+		Tags:     form["tag"],
+		Weight:   DefaultServiceWeight,
+		Protocol: form.Get("protocol"),
+		Status:   ServiceStatusPassing,
+	}
+	if v := form.Get("status"); v != "" {
+		if !IsValidServiceStatus(v) {
+			return nil, errors.New("invalid status:" + v)
 		}
-		p.LastUpdate = time.Now().UnixMilli()
-		p.Publishment[serviceName] = struct{ Addr string }{Addr: address}
+		item.Status = v
 	}
-	f()
+	for _, v := range form["meta"] {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, errors.New("invalid metadata:" + v)
+		}
+		if item.Metadata == nil {
+			item.Metadata = map[string]string{}
+		}
+		item.Metadata[key] = value
+	}
+	if v := form.Get("weight"); v != "" {
+		weight, err := strconv.Atoi(v)
+		if err != nil || weight <= 0 {
+			return nil, errors.New("invalid weight:" + v)
+		}
+		item.Weight = weight
+	}
+	if v := form.Get("port"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, errors.New("invalid port:" + v)
+		}
+		item.Port = port
+	}
+	return item, nil
+}
 
-	w.WriteHeader(http.StatusOK)
+func (this *HttpEndpoint) deleteService(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = r.ParseForm()
+	serviceName := r.FormValue("name")
+	nodeId := r.FormValue("node")
+
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	if !isValidServiceName(serviceName) || nodeId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] delete service:", namespace, serviceName, nodeId)
+	}
+
+	found, err := this.removeServices(r, client, serviceName)
+	writeUpdateResult(w, found, err)
+}
+
+// deregisterNode deletes all the services published by the node, e.g. when the node shuts down
+func (this *HttpEndpoint) deregisterNode(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = r.ParseForm()
+	nodeId := r.FormValue("node")
+
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	if nodeId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] deregister node:", namespace, nodeId)
+	}
+
+	if _, err := this.removeServices(r, client, ""); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// heartbeat refreshes all the registrations of the node.
+// 404 is returned when the node has no registration, e.g. expired or the endpoint restarted, and it should publish again.
+func (this *HttpEndpoint) heartbeat(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = r.ParseForm()
+	nodeId := r.FormValue("node")
+
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	if nodeId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	found, err := this.refreshPublishments(r, client)
+	writeUpdateResult(w, found, err)
+}
+
+// refreshPublishments renews all the registrations of the node once the client certificate of the request
+// permits them. It returns false if the node has no registration.
+func (this *HttpEndpoint) refreshPublishments(r *http.Request, client clientKey) (bool, error) {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+	p, ok := this.publicClients[client]
+	if !ok {
+		return false, nil
+	}
+	if err := this.permitServices(r, client.Namespace, this.publishedServices(client)...); err != nil {
+		return true, err
+	}
+	now := time.Now()
+	for _, v := range p.Publishment {
+		v.LastUpdate = now
+	}
+	return true, nil
+}
+
+func (this *HttpEndpoint) queryStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if _, ok := this.authorize(w, r, iface.RoleAdmin); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	result := this.collectStatus()
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Println("[ERROR] marshal status error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := w.Write(data); err != nil {
+		log.Println("[ERROR] queryStatus response fail:", err)
+	}
+}
+
+func (this *HttpEndpoint) collectStatus() map[string]any {
+	this.statusProvidersLock.RLock()
+	defer this.statusProvidersLock.RUnlock()
+	result := make(map[string]any)
+	for name, provider := range this.statusProviders {
+		result[name] = provider()
+	}
+	return result
 }
@@ -0,0 +1,311 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+	"github.com/meidoworks/nekoq-bootstrap/internal/svccheck"
+)
+
+const (
+	DefaultServiceCheckInterval    = 10 * time.Second
+	DefaultServiceCheckMaxFailures = 3
+
+	minServiceCheckInterval = time.Second
+)
+
+// serviceCheck is declared by the registration and run by the endpoint owning the registration
+type serviceCheck struct {
+	svccheck.Check
+	Interval time.Duration
+	// MaxFailures consecutive failures mark the instance critical, or deregister it if Deregister is true
+	MaxFailures int
+	Deregister  bool
+
+	failures int
+	nextRun  time.Time
+	running  bool
+	// critical is true when the instance is marked critical by the check
+	critical bool
+}
+
+func (c *serviceCheck) sameAs(other *serviceCheck) bool {
+	return other != nil && c.Check == other.Check && c.Interval == other.Interval &&
+		c.MaxFailures == other.MaxFailures && c.Deregister == other.Deregister
+}
+
+// parseServiceCheck reads the check from form values: check(tcp/http/grpc/resp), check_address(default: address),
+// check_path, check_service, check_interval, check_timeout, check_failures and check_deregister.
+// nil is returned when no check is declared. remoteAddr is the address of the registrant. See isCheckAddressAllowed.
+func (this *HttpEndpoint) parseServiceCheck(form url.Values, remoteAddr string) (*serviceCheck, error) {
+	checkType := form.Get("check")
+	if checkType == "" {
+		return nil, nil
+	}
+	c := &serviceCheck{
+		Check: svccheck.Check{
+			Type:    checkType,
+			Address: form.Get("check_address"),
+			Path:    form.Get("check_path"),
+			Service: form.Get("check_service"),
+		},
+		Interval:    DefaultServiceCheckInterval,
+		MaxFailures: DefaultServiceCheckMaxFailures,
+	}
+	if c.Address == "" {
+		c.Address = form.Get("address")
+	}
+	if v := form.Get("check_interval"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < minServiceCheckInterval {
+			return nil, errors.New("invalid check interval:" + v)
+		}
+		c.Interval = interval
+	}
+	if v := form.Get("check_timeout"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, errors.New("invalid check timeout:" + v)
+		}
+		c.Timeout = timeout
+	}
+	if v := form.Get("check_failures"); v != "" {
+		failures, err := strconv.Atoi(v)
+		if err != nil || failures <= 0 {
+			return nil, errors.New("invalid check failures:" + v)
+		}
+		c.MaxFailures = failures
+	}
+	if v := form.Get("check_deregister"); v != "" {
+		deregister, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("invalid check deregister:" + v)
+		}
+		c.Deregister = deregister
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if !this.isCheckAddressAllowed(c.Address, remoteAddr) {
+		return nil, errors.New("check address is not allowed:" + c.Address)
+	}
+	return c, nil
+}
+
+// isCheckAddressAllowed reports whether the check declared by the registrant at remoteAddr may probe checkAddress.
+// The check is run by this node, so the registered address chosen by the registrant is not trusted:
+// checkAddress must be the ip of the registrant itself, or an ip within ServiceCheckNetworks.
+// Host names are rejected since they may resolve to any host.
+func (this *HttpEndpoint) isCheckAddressAllowed(checkAddress, remoteAddr string) bool {
+	checkHost, _, err := net.SplitHostPort(checkAddress)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(checkHost)
+	if ip == nil {
+		return false
+	}
+	if remoteHost, _, err := net.SplitHostPort(remoteAddr); err == nil && ip.Equal(net.ParseIP(remoteHost)) {
+		return true
+	}
+	return this.ServiceCheckNetworks.Contains(checkHost)
+}
+
+func (this *HttpEndpoint) RunServiceChecks() {
+	for {
+		time.Sleep(1 * time.Second)
+		this.runDueChecks(time.Now())
+	}
+}
+
+// runDueChecks starts the checks reaching their interval in background.
+// The returned WaitGroup is done once all the started checks finish.
+func (this *HttpEndpoint) runDueChecks(now time.Time) *sync.WaitGroup {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+
+	wg := new(sync.WaitGroup)
+	for client, p := range this.publicClients {
+		for serviceName, v := range p.Publishment {
+			check := v.Check
+			if check == nil || check.running || now.Before(check.nextRun) {
+				continue
+			}
+			check.running = true
+			check.nextRun = now.Add(check.Interval)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := check.Run(context.Background())
+				this.applyCheckResult(client, serviceName, v, err)
+			}()
+		}
+	}
+	return wg
+}
+
+func (this *HttpEndpoint) applyCheckResult(client clientKey, serviceName string, pub *publishment, checkErr error) {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+
+	check := pub.Check
+	check.running = false
+	// the registration may be removed or replaced while checking
+	if p, ok := this.publicClients[client]; !ok || p.Publishment[serviceName] != pub {
+		return
+	}
+
+	var status string
+	if checkErr == nil {
+		check.failures = 0
+		if !check.critical {
+			return
+		}
+		check.critical = false
+		status = ServiceStatusPassing
+		log.Println("[INFO] service check passed again. service:", serviceName, "node:", client.NodeId, "namespace:", client.Namespace)
+	} else {
+		check.failures++
+		if this.DebugPrint {
+			log.Println("[DEBUG] service check failed. service:", serviceName, "node:", client.NodeId, "namespace:", client.Namespace, "error:", checkErr)
+		}
+		if check.failures < check.MaxFailures {
+			return
+		}
+		if check.Deregister {
+			log.Println("[INFO] deregister service after check failures. service:", serviceName, "node:", client.NodeId, "namespace:", client.Namespace, "error:", checkErr)
+			this.removePublishment(client, serviceName)
+			return
+		}
+		check.critical = true
+		if pub.Instance.Status == ServiceStatusCritical {
+			return
+		}
+		status = ServiceStatusCritical
+		log.Println("[INFO] mark service critical after check failures. service:", serviceName, "node:", client.NodeId, "namespace:", client.Namespace, "error:", checkErr)
+	}
+	if _, err := this.updatePublishments(client, serviceName, func(instance *serviceInstance) {
+		instance.Status = status
+	}); err != nil {
+		log.Println("[ERROR] update service status error:", err)
+	}
+}
+
+// updatePublishments applies the update to the registrations of the node and publishes them again.
+// All the registrations of the node are updated when serviceName is empty.
+// It returns false if no registration is found. this.rwlock must be held.
+func (this *HttpEndpoint) updatePublishments(client clientKey, serviceName string, update func(instance *serviceInstance)) (bool, error) {
+	p, ok := this.publicClients[client]
+	if !ok {
+		return false, nil
+	}
+	found := false
+	for name, v := range p.Publishment {
+		if serviceName != "" && name != serviceName {
+			continue
+		}
+		found = true
+		update(&v.Instance)
+		if err := this.Storage.PublishService(serviceKey(client.Namespace, name), v.Instance.item(client.NodeId)); err != nil {
+			return true, err
+		}
+	}
+	return found, nil
+}
+
+// updateServiceStatus sets the health status of the instance, e.g. reported by the instance itself
+func (this *HttpEndpoint) updateServiceStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = r.ParseForm()
+	serviceName := r.FormValue("name")
+	nodeId := r.FormValue("node")
+	status := r.FormValue("status")
+
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	if serviceName == "" || nodeId == "" || !IsValidServiceStatus(status) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] update service status:", namespace, serviceName, nodeId, status)
+	}
+
+	found, err := this.updateInstances(r, client, serviceName, func(instance *serviceInstance) {
+		instance.Status = status
+	})
+	writeUpdateResult(w, found, err)
+}
+
+// updateMaintenance drains the instance without deregistering it, or brings it back.
+// All the services of the node are updated when name is omitted.
+func (this *HttpEndpoint) updateMaintenance(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	_ = r.ParseForm()
+	serviceName := r.FormValue("name")
+	nodeId := r.FormValue("node")
+
+	namespace, ok := this.authorize(w, r, iface.RoleRegister)
+	if !ok {
+		return
+	}
+	client := clientKey{Namespace: namespace, NodeId: nodeId}
+	enable, err := strconv.ParseBool(r.FormValue("enable"))
+	if err != nil || nodeId == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] update maintenance:", namespace, serviceName, nodeId, enable)
+	}
+
+	found, err := this.updateInstances(r, client, serviceName, func(instance *serviceInstance) {
+		instance.Maintenance = enable
+	})
+	writeUpdateResult(w, found, err)
+}
+
+// updateInstances locks the registrations and applies the update once the client certificate of the request
+// permits the services. See updatePublishments.
+func (this *HttpEndpoint) updateInstances(r *http.Request, client clientKey, serviceName string, update func(instance *serviceInstance)) (bool, error) {
+	this.rwlock.Lock()
+	defer this.rwlock.Unlock()
+	services := []string{serviceName}
+	if serviceName == "" {
+		services = this.publishedServices(client)
+	}
+	if err := this.permitServices(r, client.Namespace, services...); err != nil {
+		return true, err
+	}
+	return this.updatePublishments(client, serviceName, update)
+}
+
+func writeUpdateResult(w http.ResponseWriter, found bool, err error) {
+	if e := (*apiError)(nil); errors.As(err, &e) {
+		w.WriteHeader(e.Status)
+		return
+	}
+	if err != nil {
+		log.Println("[ERROR] update service error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
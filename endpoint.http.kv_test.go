@@ -0,0 +1,66 @@
+package bootstrap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/httpserver"
+	"github.com/meidoworks/nekoq-bootstrap/internal/service"
+)
+
+type testKVStorage map[string][]byte
+
+func (s testKVStorage) Put(k, v []byte) error {
+	s[string(k)] = v
+	return nil
+}
+
+func (s testKVStorage) Get(k []byte) ([]byte, bool, error) {
+	v, ok := s[string(k)]
+	return v, ok, nil
+}
+
+func TestHttpEndpointKVNamespace(t *testing.T) {
+	ep := newTestEndpoint(t, nil, true, "admin")
+	if err := ep.UpdateNamespaces([]*Namespace{
+		{Name: "team1", AccessPassword: "pw1"},
+		{Name: "team2", AccessPassword: "pw2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	kv := testKVStorage{}
+	server := httpserver.NewHttpServer("")
+	service.NewHttpServiceContainer(server).SetAuthorizer(ep.AuthorizeRequest).SetupKVStorage(kv)
+	do := func(method, path, password, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Access-Password", password)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPut, "/services/kvstore/k?namespace=team1", "pw1", "v1"); w.Code != http.StatusOK {
+		t.Fatal("put failed:", w.Code)
+	}
+	if w := do(http.MethodPut, "/services/kvstore/k", "admin", "v0"); w.Code != http.StatusOK {
+		t.Fatal("put failed:", w.Code)
+	}
+	if string(kv["team1/k"]) != "v1" || string(kv["k"]) != "v0" {
+		t.Fatal("keys should be prefixed with the namespace:", kv)
+	}
+
+	if w := do(http.MethodGet, "/services/kvstore/k?namespace=team1", "pw1", ""); w.Code != http.StatusOK || w.Body.String() != "v1" {
+		t.Fatal("unexpected value:", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/services/kvstore/k?namespace=team1", "pw2", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("password of another namespace should be rejected:", w.Code)
+	}
+	if w := do(http.MethodGet, "/services/kvstore/k?namespace=team2", "pw2", ""); w.Code != http.StatusNotFound {
+		t.Fatal("keys of another namespace should not be visible:", w.Code)
+	}
+	if w := do(http.MethodGet, "/services/kvstore/k", "pw1", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("namespace password should not read keys of the default namespace:", w.Code)
+	}
+}
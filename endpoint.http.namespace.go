@@ -0,0 +1,105 @@
+package bootstrap
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultNamespace holds the services registered without namespace. It is only accessible with the global access password.
+const DefaultNamespace = ""
+
+var namespaceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Namespace isolates the services and dns records of a team or an environment
+type Namespace struct {
+	Name string
+	// AccessPassword grants access to this namespace only. The global access password grants access to all namespaces.
+	AccessPassword string
+	// DnsSuffixes are the domains whose records are managed in this namespace
+	DnsSuffixes []string
+}
+
+func (n *Namespace) Validate() error {
+	if !namespaceNamePattern.MatchString(n.Name) {
+		return errors.New("invalid namespace name:" + n.Name)
+	}
+	return nil
+}
+
+func (n *Namespace) ownsDomain(domain string) bool {
+	for _, suffix := range n.DnsSuffixes {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKey identifies a node publishing services in a namespace
+type clientKey struct {
+	Namespace string
+	NodeId    string
+}
+
+// isValidServiceName rejects empty names and names containing "/", which separates the namespace in serviceKey.
+// Otherwise service "team/foo" of the default namespace would be service "foo" of namespace "team".
+func isValidServiceName(name string) bool {
+	return name != "" && !strings.Contains(name, "/")
+}
+
+// serviceKey is the name of the service in storage. Services of the default namespace keep their names
+// so that they stay compatible with peers of previous versions.
+func serviceKey(namespace, service string) string {
+	if namespace == DefaultNamespace {
+		return service
+	}
+	return namespace + "/" + service
+}
+
+// BuildNamespaces validates the namespaces and normalizes their dns suffixes
+func BuildNamespaces(namespaces []*Namespace) (map[string]*Namespace, error) {
+	mapping := make(map[string]*Namespace, len(namespaces))
+	for _, v := range namespaces {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := mapping[v.Name]; ok {
+			return nil, errors.New("duplicated namespace:" + v.Name)
+		}
+		ns := &Namespace{Name: v.Name, AccessPassword: v.AccessPassword}
+		for _, suffix := range v.DnsSuffixes {
+			ns.DnsSuffixes = append(ns.DnsSuffixes, dns.Fqdn(strings.ToLower(suffix)))
+		}
+		mapping[v.Name] = ns
+	}
+	return mapping, nil
+}
+
+// UpdateNamespaces replaces all the namespaces. It takes effect on the following requests.
+// Services of removed namespaces are kept until they expire.
+func (this *HttpEndpoint) UpdateNamespaces(namespaces []*Namespace) error {
+	mapping, err := BuildNamespaces(namespaces)
+	if err != nil {
+		return err
+	}
+
+	this.authLock.Lock()
+	defer this.authLock.Unlock()
+	this.namespaces = mapping
+	return nil
+}
+
+// ownsDomain reports whether records of the domain are managed in the namespace.
+// The default namespace manages all the records.
+func (this *HttpEndpoint) ownsDomain(namespace, domain string) bool {
+	if namespace == DefaultNamespace {
+		return true
+	}
+	this.authLock.RLock()
+	defer this.authLock.RUnlock()
+	ns, ok := this.namespaces[namespace]
+	return ok && ns.ownsDomain(domain)
+}
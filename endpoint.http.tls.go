@@ -0,0 +1,168 @@
+package bootstrap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	ClientAuthRequire       = "require"
+	ClientAuthVerifyIfGiven = "verify_if_given"
+)
+
+// CertIdentity permits the clients presenting certificates of Name to change the registrations of Services
+type CertIdentity struct {
+	// Name is matched against the common name and the subject alternative names of client certificates,
+	// e.g. orders.example.com or spiffe://example.com/orders
+	Name string
+	// Services are path.Match patterns of service names, e.g. orders-*.
+	// Services of namespaces are named as namespace/service, e.g. team1/orders or team1/*.
+	Services []string
+}
+
+func (c *CertIdentity) Validate() error {
+	if c.Name == "" {
+		return errors.New("certificate identity name is required")
+	}
+	if len(c.Services) == 0 {
+		return errors.New("certificate identity requires services:" + c.Name)
+	}
+	for _, pattern := range c.Services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.New("invalid service pattern of certificate identity " + c.Name + ":" + pattern)
+		}
+	}
+	return nil
+}
+
+func (c *CertIdentity) permits(service string) bool {
+	for _, pattern := range c.Services {
+		if ok, _ := path.Match(pattern, service); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidateCertIdentities(identities []*CertIdentity) error {
+	for _, v := range identities {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewServerTLSConfig loads the certificate of the endpoint. Client certificates are verified against clientCAFile
+// when it is not empty, and clientAuth is either ClientAuthRequire or ClientAuthVerifyIfGiven.
+func NewServerTLSConfig(certFile, keyFile, clientCAFile, clientAuth string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificate found in client ca file:" + clientCAFile)
+	}
+	config.ClientCAs = pool
+	switch clientAuth {
+	case ClientAuthRequire, "":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthVerifyIfGiven:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, errors.New("unknown client auth:" + clientAuth)
+	}
+	return config, nil
+}
+
+// UpdateCertIdentities replaces all the certificate identities. It takes effect on the following requests.
+// Registrations are not bound to certificates when there is no identity.
+func (this *HttpEndpoint) UpdateCertIdentities(identities []*CertIdentity) error {
+	if err := ValidateCertIdentities(identities); err != nil {
+		return err
+	}
+	this.authLock.Lock()
+	defer this.authLock.Unlock()
+	this.certIdentities = identities
+	return nil
+}
+
+// certificateNames returns the common name and the subject alternative names of the verified client certificate
+func certificateNames(r *http.Request) []string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, v := range cert.IPAddresses {
+		names = append(names, v.String())
+	}
+	for _, v := range cert.URIs {
+		names = append(names, v.String())
+	}
+	return names
+}
+
+// permitServices checks that the client certificate of the request permits changing the registrations of the services.
+// It returns a 403 apiError when any of the services is not permitted.
+func (this *HttpEndpoint) permitServices(r *http.Request, namespace string, services ...string) error {
+	this.authLock.RLock()
+	defer this.authLock.RUnlock()
+	if len(this.certIdentities) == 0 {
+		return nil
+	}
+
+	names := certificateNames(r)
+	var identities []*CertIdentity
+	for _, v := range this.certIdentities {
+		if slices.Contains(names, v.Name) {
+			identities = append(identities, v)
+		}
+	}
+	for _, service := range services {
+		key := serviceKey(namespace, service)
+		if !slices.ContainsFunc(identities, func(identity *CertIdentity) bool {
+			return identity.permits(key)
+		}) {
+			log.Println("[AUDIT] certificate:", strings.Join(names, ","), "service:", key, "allowed:", false,
+				"remote:", r.RemoteAddr, "request:", r.Method, r.URL.Path)
+			return newApiError(http.StatusForbidden, "service is not permitted by the client certificate:"+service)
+		}
+	}
+	return nil
+}
+
+// publishedServices returns the names of the services registered by the node. this.rwlock must be held.
+func (this *HttpEndpoint) publishedServices(client clientKey) []string {
+	var services []string
+	if p, ok := this.publicClients[client]; ok {
+		for name := range p.Publishment {
+			services = append(services, name)
+		}
+	}
+	return services
+}
@@ -0,0 +1,171 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func TestHttpEndpointClientCertificate(t *testing.T) {
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil)
+	server := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	ordersUri, _ := url.Parse("spiffe://example.com/orders")
+	orders := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "orders-client"},
+		URIs:        []*url.URL{ordersUri},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	billing := newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "billing"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	tlsConfig, err := NewServerTLSConfig(write("server.pem", server.certPEM), write("server.key", server.keyPEM),
+		write("ca.pem", ca.certPEM), ClientAuthVerifyIfGiven)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep := newTestEndpoint(t, nil, false, "")
+	if err := ep.UpdateCertIdentities([]*CertIdentity{{Name: "orders", Services: []string{"["}}}); err == nil {
+		t.Fatal("invalid pattern should be rejected")
+	}
+	if err := ep.UpdateCertIdentities([]*CertIdentity{
+		{Name: "spiffe://example.com/orders", Services: []string{"orders", "orders-*"}},
+		{Name: "billing", Services: []string{"billing"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(ep.Router)
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	newClient := func(cert *testCert) *http.Client {
+		config := &tls.Config{RootCAs: pool}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config.Certificates = []tls.Certificate{pair}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+	do := func(client *http.Client, method, path string) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	ordersClient, billingClient, anonymous := newClient(orders), newClient(billing), newClient(nil)
+
+	for _, v := range []struct {
+		code   int
+		client *http.Client
+		method string
+		path   string
+	}{
+		{http.StatusOK, ordersClient, http.MethodPost, "/service?name=orders&node=n1&address=10.0.0.1:80"},
+		{http.StatusOK, ordersClient, http.MethodPost, "/service?name=orders-admin&node=n1&address=10.0.0.1:81"},
+		{http.StatusForbidden, ordersClient, http.MethodPost, "/service?name=billing&node=n1&address=10.0.0.1:82"},
+		{http.StatusForbidden, anonymous, http.MethodPost, "/service?name=orders&node=n3&address=10.0.0.3:80"},
+		{http.StatusOK, billingClient, http.MethodPost, "/service?name=billing&node=n2&address=10.0.0.2:80"},
+		{http.StatusForbidden, billingClient, http.MethodDelete, "/service?name=orders&node=n1"},
+		{http.StatusForbidden, billingClient, http.MethodDelete, "/service/node?node=n1"},
+		{http.StatusForbidden, billingClient, http.MethodPut, "/service/maintenance?node=n1&enable=true"},
+		{http.StatusForbidden, billingClient, http.MethodPut, "/service/status?name=orders&node=n1&status=critical"},
+		{http.StatusForbidden, billingClient, http.MethodPut, "/service/heartbeat?node=n1"},
+		{http.StatusForbidden, billingClient, http.MethodPut, "/v1/nodes/n1/heartbeat"},
+		{http.StatusNoContent, ordersClient, http.MethodPut, "/service/heartbeat?node=n1"},
+		{http.StatusNoContent, ordersClient, http.MethodPut, "/v1/nodes/n1/heartbeat"},
+		// queries are not bound to certificates
+		{http.StatusOK, anonymous, http.MethodGet, "/service?name=orders"},
+		{http.StatusNoContent, ordersClient, http.MethodPut, "/service/maintenance?node=n1&enable=true"},
+		{http.StatusNoContent, ordersClient, http.MethodDelete, "/v1/nodes/n1"},
+	} {
+		if code := do(v.client, v.method, v.path); code != v.code {
+			t.Fatal("unexpected status:", code, v.method, v.path)
+		}
+	}
+	if items, _ := ep.Storage.GetServiceList("orders"); len(items) != 0 {
+		t.Fatal("services should be deregistered:", items)
+	}
+}
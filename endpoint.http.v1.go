@@ -0,0 +1,373 @@
+package bootstrap
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/iface"
+)
+
+const maxApiRequestSize = 64 * 1024
+
+// openApiDocument describes the /v1 api and is served at GET /v1/openapi.json
+//
+//go:embed endpoint.http.v1.openapi.json
+var openApiDocument []byte
+
+// apiErrorCodes are the codes of the error body of the /v1 api by http status
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_argument",
+	http.StatusUnauthorized:        "unauthenticated",
+	http.StatusForbidden:           "permission_denied",
+	http.StatusNotFound:            "not_found",
+	http.StatusInternalServerError: "internal",
+}
+
+// apiError is rejected requests of the http api. Other errors are reported as internal errors.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newApiError(status int, message string) *apiError {
+	return &apiError{Status: status, Code: apiErrorCodes[status], Message: message}
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ":" + e.Message
+}
+
+// v1ErrorBody renders errors of the /v1 api as {"code": code, "message": message}
+func v1ErrorBody(e *apiError) any {
+	return e
+}
+
+// handle adapts the handler to the router. Errors are rendered by errorBody.
+func (this *HttpEndpoint) handle(h iface.HttpHandler, errorBody func(e *apiError) any) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		result, err := h(r, params)
+		if err != nil {
+			var e *apiError
+			if !errors.As(err, &e) {
+				log.Println("[ERROR] http api error:", r.Method, r.URL.Path, err)
+				e = newApiError(http.StatusInternalServerError, "internal error")
+			}
+			result = iface.JsonResult(e.Status, errorBody(e))
+		}
+		if err := result.Render(w); err != nil {
+			log.Println("[ERROR] response fail:", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+func (this *HttpEndpoint) registerV1Api(router *httprouter.Router) {
+	v1 := func(h iface.HttpHandler) httprouter.Handle {
+		return this.handle(h, v1ErrorBody)
+	}
+	router.GET("/v1/openapi.json", v1(this.openApiV1))
+	router.GET("/v1/status", v1(this.queryStatusV1))
+	router.GET("/v1/services/:name", v1(this.queryServiceV1))
+	router.PUT("/v1/services/:name/nodes/:node", v1(this.publishServiceV1))
+	router.DELETE("/v1/services/:name/nodes/:node", v1(this.deleteServiceV1))
+	router.PUT("/v1/services/:name/nodes/:node/status", v1(this.updateServiceStatusV1))
+	router.PUT("/v1/services/:name/nodes/:node/maintenance", v1(this.updateMaintenanceV1))
+	router.DELETE("/v1/nodes/:node", v1(this.deregisterNodeV1))
+	router.PUT("/v1/nodes/:node/heartbeat", v1(this.heartbeatV1))
+	router.PUT("/v1/nodes/:node/maintenance", v1(this.updateMaintenanceV1))
+	this.registerDnsRecordApi(router, "/v1/dns", v1ErrorBody)
+}
+
+// serviceRegistration is the request body of PUT /v1/services/:name/nodes/:node
+type serviceRegistration struct {
+	Address  string            `json:"address"`
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Port     int               `json:"port,omitempty"`
+	Status   string            `json:"status,omitempty"`
+	// TTL is a duration, e.g. 30s
+	TTL   string                    `json:"ttl,omitempty"`
+	Check *serviceCheckRegistration `json:"check,omitempty"`
+}
+
+// serviceCheckRegistration is the check of the registration. Interval and Timeout are durations, e.g. 10s.
+type serviceCheckRegistration struct {
+	Type       string `json:"type"`
+	Address    string `json:"address,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Service    string `json:"service,omitempty"`
+	Interval   string `json:"interval,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	Failures   int    `json:"failures,omitempty"`
+	Deregister bool   `json:"deregister,omitempty"`
+}
+
+// form converts the registration into the form values of POST /service so that both apis share the validation.
+// Metadata is not converted since its keys may contain '='.
+func (reg *serviceRegistration) form(nodeId string) url.Values {
+	form := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	setInt := func(key string, value int) {
+		if value != 0 {
+			form.Set(key, strconv.Itoa(value))
+		}
+	}
+	set("node", nodeId)
+	set("address", reg.Address)
+	form["tag"] = reg.Tags
+	setInt("weight", reg.Weight)
+	set("protocol", reg.Protocol)
+	setInt("port", reg.Port)
+	set("status", reg.Status)
+	set("ttl", reg.TTL)
+	if c := reg.Check; c != nil {
+		set("check", c.Type)
+		set("check_address", c.Address)
+		set("check_path", c.Path)
+		set("check_service", c.Service)
+		set("check_interval", c.Interval)
+		set("check_timeout", c.Timeout)
+		setInt("check_failures", c.Failures)
+		if c.Deregister {
+			form.Set("check_deregister", "true")
+		}
+	}
+	return form
+}
+
+type serviceStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type maintenanceRequest struct {
+	Enable *bool `json:"enable"`
+}
+
+func decodeApiRequest(r *http.Request, v any) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxApiRequestSize)).Decode(v); err != nil {
+		return newApiError(http.StatusBadRequest, "invalid request body:"+err.Error())
+	}
+	return nil
+}
+
+// authorizeNode returns the node of the path in the namespace of the request which is allowed to register
+func (this *HttpEndpoint) authorizeNode(r *http.Request, params httprouter.Params) (clientKey, error) {
+	namespace, err := this.authorizeApi(r, iface.RoleRegister)
+	if err != nil {
+		return clientKey{}, err
+	}
+	return clientKey{Namespace: namespace, NodeId: params.ByName("node")}, nil
+}
+
+func (this *HttpEndpoint) openApiV1(_ *http.Request, _ httprouter.Params) (iface.HttpResult, error) {
+	return iface.WrapResultRender(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write(openApiDocument)
+		return err
+	}), nil
+}
+
+func (this *HttpEndpoint) queryStatusV1(r *http.Request, _ httprouter.Params) (iface.HttpResult, error) {
+	if _, err := this.authorizeApi(r, iface.RoleAdmin); err != nil {
+		return nil, err
+	}
+	return iface.JsonResult(http.StatusOK, this.collectStatus()), nil
+}
+
+func (this *HttpEndpoint) queryServiceV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	namespace, err := this.authorizeApi(r, iface.RoleDiscovery)
+	if err != nil {
+		return nil, err
+	}
+	if !isValidServiceName(params.ByName("name")) {
+		return nil, newApiError(http.StatusBadRequest, "invalid service name:"+params.ByName("name"))
+	}
+	query, err := parseServiceQuery(r.URL.Query())
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, err.Error())
+	}
+	items, index, err := this.listServices(r.Context(), namespace, params.ByName("name"), query)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		items = []*ServiceItem{}
+	}
+	result := iface.JsonResult(http.StatusOK, items)
+	return iface.WrapResultRender(func(w http.ResponseWriter) error {
+		w.Header().Set(ServiceIndexHeader, strconv.FormatUint(index, 10))
+		return result.Render(w)
+	}), nil
+}
+
+func (this *HttpEndpoint) publishServiceV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := params.ByName("name")
+	if !isValidServiceName(serviceName) {
+		return nil, newApiError(http.StatusBadRequest, "invalid service name:"+serviceName)
+	}
+	reg := new(serviceRegistration)
+	if err := decodeApiRequest(r, reg); err != nil {
+		return nil, err
+	}
+
+	form := reg.form(client.NodeId)
+	item, err := parseServiceItem(form)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, err.Error())
+	}
+	for key := range reg.Metadata {
+		if key == "" {
+			return nil, newApiError(http.StatusBadRequest, "empty metadata key")
+		}
+	}
+	if len(reg.Metadata) > 0 {
+		item.Metadata = reg.Metadata
+	}
+	ttl, err := this.parseTTLParam(form.Get("ttl"))
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, err.Error())
+	}
+	check, err := this.parseServiceCheck(form, r.RemoteAddr)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, err.Error())
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] publish service:", client.Namespace, serviceName, client.NodeId, item.Addr)
+	}
+
+	if err := this.permitServices(r, client.Namespace, serviceName); err != nil {
+		return nil, err
+	}
+	if err := this.publish(client, serviceName, item, ttl, check); err != nil {
+		return nil, err
+	}
+	return iface.JsonResult(http.StatusOK, item), nil
+}
+
+func (this *HttpEndpoint) deleteServiceV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := params.ByName("name")
+	if !isValidServiceName(serviceName) {
+		return nil, newApiError(http.StatusBadRequest, "invalid service name:"+serviceName)
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] delete service:", client.Namespace, serviceName, client.NodeId)
+	}
+
+	found, err := this.removeServices(r, client, serviceName)
+	return updateResultV1(client, serviceName, found, err)
+}
+
+func (this *HttpEndpoint) deregisterNodeV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] deregister node:", client.Namespace, client.NodeId)
+	}
+
+	if _, err := this.removeServices(r, client, ""); err != nil {
+		return nil, err
+	}
+	return iface.StatusOnlyResult(http.StatusNoContent), nil
+}
+
+func (this *HttpEndpoint) heartbeatV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+	found, err := this.refreshPublishments(r, client)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newApiError(http.StatusNotFound, "node has no registration:"+client.NodeId)
+	}
+	return iface.StatusOnlyResult(http.StatusNoContent), nil
+}
+
+func (this *HttpEndpoint) updateServiceStatusV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := params.ByName("name")
+	req := new(serviceStatusRequest)
+	if err := decodeApiRequest(r, req); err != nil {
+		return nil, err
+	}
+	if !IsValidServiceStatus(req.Status) {
+		return nil, newApiError(http.StatusBadRequest, "invalid status:"+req.Status)
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] update service status:", client.Namespace, serviceName, client.NodeId, req.Status)
+	}
+
+	found, err := this.updateInstances(r, client, serviceName, func(instance *serviceInstance) {
+		instance.Status = req.Status
+	})
+	return updateResultV1(client, serviceName, found, err)
+}
+
+// updateMaintenanceV1 updates the service of the node, or all the services of the node without service in path
+func (this *HttpEndpoint) updateMaintenanceV1(r *http.Request, params httprouter.Params) (iface.HttpResult, error) {
+	client, err := this.authorizeNode(r, params)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := params.ByName("name")
+	req := new(maintenanceRequest)
+	if err := decodeApiRequest(r, req); err != nil {
+		return nil, err
+	}
+	if req.Enable == nil {
+		return nil, newApiError(http.StatusBadRequest, "enable is required")
+	}
+
+	if this.DebugPrint {
+		log.Println("[DEBUG] update maintenance:", client.Namespace, serviceName, client.NodeId, *req.Enable)
+	}
+
+	found, err := this.updateInstances(r, client, serviceName, func(instance *serviceInstance) {
+		instance.Maintenance = *req.Enable
+	})
+	return updateResultV1(client, serviceName, found, err)
+}
+
+func updateResultV1(client clientKey, serviceName string, found bool, err error) (iface.HttpResult, error) {
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, newApiError(http.StatusNotFound, "registration not found. service:"+serviceName+" node:"+client.NodeId)
+	}
+	return iface.StatusOnlyResult(http.StatusNoContent), nil
+}
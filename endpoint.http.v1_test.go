@@ -0,0 +1,136 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+func TestHttpEndpointV1Api(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, true, "pw")
+	ep.ServiceCheckNetworks, _ = dnscore.ParseCIDRList([]string{"10.0.0.0/8"})
+
+	expectError := func(w *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if w.Code != status {
+			t.Fatal("unexpected status:", w.Code, w.Body.String())
+		}
+		e := new(apiError)
+		if err := json.Unmarshal(w.Body.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Code != code || e.Message == "" {
+			t.Fatal("unexpected error body:", w.Body.String())
+		}
+	}
+
+	w := ep.do(http.MethodPut, "/v1/services/svc1/nodes/n1", `{
+		"address": "10.0.0.1:80",
+		"tags": ["zone=a"],
+		"metadata": {"k=1": "v"},
+		"weight": 5,
+		"ttl": "30s",
+		"check": {"type": "tcp", "interval": "5s"}
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatal("register failed:", w.Code, w.Body.String())
+	}
+	item := new(ServiceItem)
+	if err := json.Unmarshal(w.Body.Bytes(), item); err != nil {
+		t.Fatal(err)
+	}
+	if item.NodeId != "n1" || item.Weight != 5 || item.Metadata["k=1"] != "v" || item.Status != ServiceStatusPassing {
+		t.Fatal("unexpected registered instance:", w.Body.String())
+	}
+	p := ep.publicClients[clientKey{NodeId: "n1"}].Publishment["svc1"]
+	if p.TTL.String() != "30s" || p.Check == nil || p.Check.Address != "10.0.0.1:80" {
+		t.Fatal("unexpected registration:", p)
+	}
+
+	expectError(ep.do(http.MethodPut, "/v1/services/svc1/nodes/n2", `{"address": "10.0.0.2:80", "weight": -1}`), http.StatusBadRequest, "invalid_argument")
+	expectError(ep.do(http.MethodPut, "/v1/services/svc1/nodes/n2", `{"address": `), http.StatusBadRequest, "invalid_argument")
+	expectError(ep.do(http.MethodPut, "/v1/services/svc1/nodes/n2", `{"address": "10.0.0.2:80", "check": {"type": "icmp"}}`), http.StatusBadRequest, "invalid_argument")
+
+	expectError(ep.doAs(http.MethodGet, "/v1/services/svc1", "", "", ""), http.StatusUnauthorized, "unauthenticated")
+
+	w = ep.do(http.MethodGet, "/v1/services/svc1?tag=zone=a", "")
+	var items []*ServiceItem
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Addr != "10.0.0.1:80" || w.Header().Get(ServiceIndexHeader) == "" {
+		t.Fatal("unexpected query result:", w.Body.String())
+	}
+
+	if w := ep.do(http.MethodPut, "/v1/nodes/n1/maintenance", `{"enable": true}`); w.Code != http.StatusNoContent {
+		t.Fatal("maintenance failed:", w.Code, w.Body.String())
+	}
+	expectError(ep.do(http.MethodPut, "/v1/nodes/n1/maintenance", `{}`), http.StatusBadRequest, "invalid_argument")
+	if w := ep.do(http.MethodGet, "/v1/services/svc1", ""); w.Body.String() != "[]" {
+		t.Fatal("instance in maintenance should not be returned:", w.Body.String())
+	}
+	if w := ep.do(http.MethodPut, "/v1/services/svc1/nodes/n1/maintenance", `{"enable": false}`); w.Code != http.StatusNoContent {
+		t.Fatal("maintenance failed:", w.Code, w.Body.String())
+	}
+	if w := ep.do(http.MethodPut, "/v1/services/svc1/nodes/n1/status", `{"status": "critical"}`); w.Code != http.StatusNoContent {
+		t.Fatal("update status failed:", w.Code, w.Body.String())
+	}
+	expectError(ep.do(http.MethodPut, "/v1/services/svc1/nodes/n1/status", `{"status": "down"}`), http.StatusBadRequest, "invalid_argument")
+	expectError(ep.do(http.MethodPut, "/v1/services/svc2/nodes/n1/status", `{"status": "passing"}`), http.StatusNotFound, "not_found")
+
+	if w := ep.do(http.MethodPut, "/v1/nodes/n1/heartbeat", ""); w.Code != http.StatusNoContent {
+		t.Fatal("heartbeat failed:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/v1/services/svc1/nodes/n1", ""); w.Code != http.StatusNoContent {
+		t.Fatal("deregister failed:", w.Code)
+	}
+	expectError(ep.do(http.MethodDelete, "/v1/services/svc1/nodes/n1", ""), http.StatusNotFound, "not_found")
+	expectError(ep.do(http.MethodPut, "/v1/nodes/n1/heartbeat", ""), http.StatusNotFound, "not_found")
+	if items, _ := store.GetServiceList("svc1"); len(items) != 0 {
+		t.Fatal("service should be deleted:", items)
+	}
+
+	if w := ep.do(http.MethodPut, "/v1/dns/records/A/a.example.dns", `{"value":"10.0.0.1"}`); w.Code != http.StatusOK {
+		t.Fatal("put record failed:", w.Code, w.Body.String())
+	}
+	expectError(ep.do(http.MethodPut, "/v1/dns/records/MX/a.example.dns", `{"value":"x"}`), http.StatusBadRequest, "invalid_argument")
+	expectError(ep.do(http.MethodGet, "/v1/dns/records/A/b.example.dns", ""), http.StatusNotFound, "not_found")
+	// the api without version keeps its error body
+	if w := ep.do(http.MethodPut, "/dns/records/MX/a.example.dns", `{"value":"x"}`); !strings.Contains(w.Body.String(), `"error"`) {
+		t.Fatal("unexpected legacy error body:", w.Body.String())
+	}
+}
+
+func TestHttpEndpointV1OpenApi(t *testing.T) {
+	ep := newTestEndpoint(t, nil, true, "pw")
+	w := ep.doAs(http.MethodGet, "/v1/openapi.json", "", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatal("unexpected status:", w.Code)
+	}
+	doc := new(struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	})
+	if err := json.Unmarshal(w.Body.Bytes(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// every operation in the document is served
+	pathParam := regexp.MustCompile(`\{[^}]+}`)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if method == "parameters" {
+				continue
+			}
+			handle, _, _ := ep.Router.Lookup(strings.ToUpper(method), pathParam.ReplaceAllString(path, "x"))
+			if handle == nil {
+				t.Fatal("operation is not served:", method, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package bootstrap
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+func TestHttpEndpointDeleteService(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	// a peer watching changes of this node
+	if _, err := store.FetchFullAndWatch("peer1"); err != nil {
+		t.Fatal(err)
+	}
+	ep := newTestEndpoint(t, store, false, "")
+
+	for _, v := range []string{
+		"/service?name=svc1&node=n1&address=10.0.0.1:80",
+		"/service?name=svc2&node=n1&address=10.0.0.1:81",
+		"/service?name=svc1&node=n2&address=10.0.0.2:80",
+	} {
+		if w := ep.do(http.MethodPost, v, ""); w.Code != http.StatusOK {
+			t.Fatal("publish failed:", w.Code)
+		}
+	}
+
+	if w := ep.do(http.MethodDelete, "/service?name=svc1", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("node is required:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/service?name=team/svc1&node=n2", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid service name should be rejected:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/service?name=svc2&node=n2", ""); w.Code != http.StatusNotFound {
+		t.Fatal("service not registered by the node should not be found:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/service?name=svc1&node=n2", ""); w.Code != http.StatusNoContent {
+		t.Fatal("delete failed:", w.Code)
+	}
+	if w := ep.do(http.MethodDelete, "/service?name=svc1&node=n2", ""); w.Code != http.StatusNotFound {
+		t.Fatal("deleted service should not be found:", w.Code)
+	}
+	if items, _ := store.GetServiceList("svc1"); len(items) != 1 || items[0].NodeId != "n1" {
+		t.Fatal("unexpected services:", items)
+	}
+	if _, ok := ep.publicClients[clientKey{NodeId: "n2"}]; ok {
+		t.Fatal("node without services should be removed from public clients")
+	}
+
+	if w := ep.do(http.MethodDelete, "/service/node?node=n1", ""); w.Code != http.StatusNoContent {
+		t.Fatal("deregister failed:", w.Code)
+	}
+	for _, name := range []string{"svc1", "svc2"} {
+		if items, _ := store.GetServiceList(name); len(items) != 0 {
+			t.Fatal("services of the node should be deleted:", items)
+		}
+	}
+	if len(ep.publicClients) != 0 {
+		t.Fatal("public clients should be cleaned")
+	}
+
+	// deletions are pending for peers
+	add, del, err := store.FetchChangesForPeerNodeRequest("peer1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	adds, _ := dataTransferNodeDataList(add[_DefaultDataKey])
+	dels, _ := dataTransferNodeDataList(del[_DefaultDataKey])
+	if len(adds) != 0 || len(dels) != 3 {
+		t.Fatal("unexpected changes for peers:", len(adds), len(dels))
+	}
+}
+
+func TestHttpEndpointServiceMetadata(t *testing.T) {
+	node1 := NewMemStore("node1", nil)
+	node2 := NewMemStore("node2", nil)
+	full, err := node1.FetchFullAndWatch("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.FullFrom("node1", full); err != nil {
+		t.Fatal(err)
+	}
+	ep1 := newTestEndpoint(t, node1, false, "")
+	ep2 := newTestEndpoint(t, node2, false, "")
+
+	if w := ep1.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1&tag=zone=a&tag=version=1.4&meta=owner=team1&weight=10&protocol=http&port=8080", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	if w := ep1.do(http.MethodPost, "/service?name=svc&node=n2&address=10.0.0.2&tag=zone=b", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	if w := ep1.do(http.MethodPost, "/service?name=svc&node=n3&address=10.0.0.3&weight=-1", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid weight should be rejected:", w.Code)
+	}
+	replicate(t, node1, node2, "node2")
+
+	for _, ep := range []*testEndpoint{ep1, ep2} {
+		items := ep.serviceItems(ep.do(http.MethodGet, "/service?name=svc&tag=zone=a&tag=version=1.4", ""))
+		if len(items) != 1 || items[0].NodeId != "n1" || items[0].Weight != 10 || items[0].Port != 8080 ||
+			items[0].Protocol != "http" || items[0].Metadata["owner"] != "team1" {
+			t.Fatal("unexpected services:", items)
+		}
+		items = ep.serviceItems(ep.do(http.MethodGet, "/service?name=svc&tag=zone=b", ""))
+		if len(items) != 1 || items[0].NodeId != "n2" || items[0].Weight != DefaultServiceWeight {
+			t.Fatal("unexpected services:", items)
+		}
+	}
+}
+
+func TestHttpEndpointWatchService(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, false, "")
+
+	w := ep.do(http.MethodGet, "/service?name=svc", "")
+	if index := w.Header().Get(ServiceIndexHeader); index != "0" {
+		t.Fatal("unexpected index of unknown service:", index)
+	}
+
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		result <- ep.do(http.MethodGet, "/service?name=svc&index=0&wait=5s", "")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// changes of other services don't wake up the watch
+	ep.do(http.MethodPost, "/service?name=other&node=n1&address=10.0.0.1", "")
+	select {
+	case <-result:
+		t.Fatal("watch should block until the service changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1", "")
+	var index string
+	select {
+	case w := <-result:
+		index = w.Header().Get(ServiceIndexHeader)
+		if index == "0" || w.Body.String() == "[]" {
+			t.Fatal("unexpected watch result:", index, w.Body.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch should return once the service changes")
+	}
+
+	// republishing the same instance is not a change
+	ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1", "")
+	start := time.Now()
+	w = ep.do(http.MethodGet, "/service?name=svc&wait=100ms&index="+index, "")
+	if time.Since(start) < 100*time.Millisecond || w.Header().Get(ServiceIndexHeader) != index {
+		t.Fatal("watch should time out with the current index:", w.Header().Get(ServiceIndexHeader))
+	}
+
+	// changes from peers
+	node2 := NewMemStore("node2", nil)
+	node2.PublishService("svc", &ServiceItem{Addr: "10.0.0.2", NodeId: "n2"})
+	full, _ := node2.FetchFullAndWatch("node1")
+	if err := store.FullFrom("node2", full); err != nil {
+		t.Fatal(err)
+	}
+	w = ep.do(http.MethodGet, "/service?name=svc&index="+index, "")
+	if w.Header().Get(ServiceIndexHeader) == index {
+		t.Fatal("changes from peers should increase the index")
+	}
+}
+
+func TestHttpEndpointServiceTTL(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, false, "")
+
+	for _, ttl := range []string{"1s", "1h", "abc"} {
+		if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1&ttl="+ttl, ""); w.Code != http.StatusBadRequest {
+			t.Fatal("ttl out of bounds should be rejected:", ttl, w.Code)
+		}
+	}
+	if w := ep.do(http.MethodPost, "/service?name=svc1&node=n1&address=10.0.0.1&ttl=1m", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	if w := ep.do(http.MethodPost, "/service?name=svc2&node=n1&address=10.0.0.1", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	if w := ep.do(http.MethodPut, "/service/heartbeat?node=n2", ""); w.Code != http.StatusNotFound {
+		t.Fatal("heartbeat of unknown node should be not found:", w.Code)
+	}
+
+	// svc2 with the default ttl expires
+	ep.expirePublishments(time.Now().Add(30 * time.Second))
+	if items, _ := store.GetServiceList("svc2"); len(items) != 0 {
+		t.Fatal("registration should expire:", items)
+	}
+	if items, _ := store.GetServiceList("svc1"); len(items) != 1 {
+		t.Fatal("registration should be kept within ttl:", items)
+	}
+
+	// heartbeat refreshes svc1
+	ep.publicClients[clientKey{NodeId: "n1"}].Publishment["svc1"].LastUpdate = time.Now().Add(-50 * time.Second)
+	if w := ep.do(http.MethodPut, "/service/heartbeat?node=n1", ""); w.Code != http.StatusNoContent {
+		t.Fatal("heartbeat failed:", w.Code)
+	}
+	ep.expirePublishments(time.Now().Add(30 * time.Second))
+	if items, _ := store.GetServiceList("svc1"); len(items) != 1 {
+		t.Fatal("registration should be refreshed by heartbeat:", items)
+	}
+	ep.expirePublishments(time.Now().Add(2 * time.Minute))
+	if items, _ := store.GetServiceList("svc1"); len(items) != 0 || len(ep.publicClients) != 0 {
+		t.Fatal("registration should expire:", items)
+	}
+}
+
+func TestHttpEndpointServiceStatus(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, false, "")
+	query := func(path string) []*ServiceItem {
+		return ep.serviceItems(ep.do(http.MethodGet, path, ""))
+	}
+
+	ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1", "")
+	ep.do(http.MethodPost, "/service?name=svc&node=n2&address=10.0.0.2&status=warning", "")
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n3&address=10.0.0.3&status=unknown", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("invalid status should be rejected:", w.Code)
+	}
+	if items := query("/service?name=svc"); len(items) != 1 || items[0].NodeId != "n1" {
+		t.Fatal("only passing instances should be returned:", items)
+	}
+	if items := query("/service?name=svc&all=true"); len(items) != 2 {
+		t.Fatal("all the instances should be returned:", items)
+	}
+
+	if w := ep.do(http.MethodPut, "/service/status?name=svc&node=n2&status=passing", ""); w.Code != http.StatusNoContent {
+		t.Fatal("update status failed:", w.Code)
+	}
+	if w := ep.do(http.MethodPut, "/service/status?name=svc&node=n3&status=passing", ""); w.Code != http.StatusNotFound {
+		t.Fatal("unknown instance should be not found:", w.Code)
+	}
+	if items := query("/service?name=svc"); len(items) != 2 {
+		t.Fatal("status update should take effect:", items)
+	}
+
+	// maintenance drains the instance and is kept when the instance publishes again
+	if w := ep.do(http.MethodPut, "/service/maintenance?node=n1&enable=true", ""); w.Code != http.StatusNoContent {
+		t.Fatal("enable maintenance failed:", w.Code)
+	}
+	ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.0.0.1", "")
+	if items := query("/service?name=svc"); len(items) != 1 || items[0].NodeId != "n2" {
+		t.Fatal("instance in maintenance should be drained:", items)
+	}
+	if items := query("/service?name=svc&all=true"); len(items) != 2 {
+		t.Fatal("instance in maintenance should be kept registered:", items)
+	}
+	if w := ep.do(http.MethodPut, "/service/maintenance?name=svc&node=n1&enable=false", ""); w.Code != http.StatusNoContent {
+		t.Fatal("disable maintenance failed:", w.Code)
+	}
+	if items := query("/service?name=svc"); len(items) != 2 {
+		t.Fatal("instance should be back:", items)
+	}
+}
+
+func TestHttpEndpointServiceCheck(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, false, "")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&address="+addr+"&check=ping", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("unknown check should be rejected:", w.Code)
+	}
+	// the address is chosen by the registrant, so checks probe the registrant itself only, 192.0.2.1 of httptest
+	for _, query := range []string{
+		"address=10.0.0.5:6379&check=resp",
+		"address=192.0.2.1:80&check=tcp&check_address=10.0.0.5:6379",
+		"address=192.0.2.1:80&check=http&check_address=localhost:80",
+	} {
+		if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&"+query, ""); w.Code != http.StatusBadRequest {
+			t.Fatal("check address other than the registrant should be rejected:", w.Code, query)
+		}
+	}
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&address=192.0.2.1:80&check=tcp&check_address=192.0.2.1:8081", ""); w.Code != http.StatusOK {
+		t.Fatal("check address of the registrant should be accepted:", w.Code)
+	}
+	ep.ServiceCheckNetworks, _ = dnscore.ParseCIDRList([]string{"10.1.0.0/16", "127.0.0.1"})
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&address=10.1.0.1:80&check=tcp", ""); w.Code != http.StatusOK {
+		t.Fatal("check address within the allowed networks should be accepted:", w.Code)
+	}
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n1&address="+addr+"&check=tcp&check_failures=2", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	if w := ep.do(http.MethodPost, "/service?name=svc&node=n2&address="+addr+"&check=tcp&check_failures=1&check_deregister=true", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+
+	now := time.Now()
+	ep.runDueChecks(now).Wait()
+	items, _ := store.GetServiceList("svc")
+	if len(items) != 1 || items[0].NodeId != "n1" || !items[0].Passing() {
+		t.Fatal("instance should be deregistered after failures:", items)
+	}
+	// checks run only after the interval
+	ep.runDueChecks(now).Wait()
+	if items, _ := store.GetServiceList("svc"); !items[0].Passing() {
+		t.Fatal("check should not run within the interval")
+	}
+	ep.runDueChecks(now.Add(DefaultServiceCheckInterval)).Wait()
+	if items, _ := store.GetServiceList("svc"); items[0].Status != ServiceStatusCritical {
+		t.Fatal("instance should be critical after failures:", items[0])
+	}
+
+	// publishing again keeps the state of the same check
+	ep.do(http.MethodPost, "/service?name=svc&node=n1&address="+addr+"&check=tcp&check_failures=2", "")
+	if items, _ := store.GetServiceList("svc"); items[0].Status != ServiceStatusCritical {
+		t.Fatal("instance should be kept critical:", items[0])
+	}
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("port is taken:", err)
+	}
+	defer listener.Close()
+	ep.runDueChecks(now.Add(2 * DefaultServiceCheckInterval)).Wait()
+	if items, _ := store.GetServiceList("svc"); !items[0].Passing() {
+		t.Fatal("instance should be passing again:", items[0])
+	}
+}
+
+func TestHttpEndpointNamespace(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	ep := newTestEndpoint(t, store, true, "admin")
+	if err := ep.UpdateNamespaces([]*Namespace{
+		{Name: "team1", AccessPassword: "pw1", DnsSuffixes: []string{"Team1.Example"}},
+		{Name: "team2", AccessPassword: "pw2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.UpdateNamespaces([]*Namespace{{Name: "a"}, {Name: "a"}}); err == nil {
+		t.Fatal("duplicated namespaces should be rejected")
+	}
+	if err := ep.UpdateNamespaces([]*Namespace{{Name: "a/b"}}); err == nil {
+		t.Fatal("namespace containing / should be rejected")
+	}
+
+	query := func(path, password string) []*ServiceItem {
+		return ep.serviceItems(ep.as(password).do(http.MethodGet, path, ""))
+	}
+
+	if w := ep.as("pw1").do(http.MethodPost, "/service?namespace=team1&name=svc&node=n1&address=10.0.0.1:80", ""); w.Code != http.StatusOK {
+		t.Fatal("publish failed:", w.Code)
+	}
+	// service team1/svc of the default namespace would be stored as service svc of namespace team1
+	for _, path := range []string{"/service?name=team1/svc&node=n2&address=10.0.0.9:80", "/service?name=&node=n2&address=10.0.0.9:80"} {
+		if w := ep.as("admin").do(http.MethodPost, path, ""); w.Code != http.StatusBadRequest {
+			t.Fatal("invalid service name should be rejected:", w.Code, path)
+		}
+	}
+	if w := ep.as("admin").do(http.MethodGet, "/service?name=team1/svc", ""); w.Code != http.StatusBadRequest {
+		t.Fatal("query of invalid service name should be rejected:", w.Code)
+	}
+	if w := ep.as("admin").do(http.MethodPut, "/v1/services/team1%2Fsvc/nodes/n2", `{"address": "10.0.0.9:80"}`); w.Code == http.StatusOK {
+		t.Fatal("invalid service name should be rejected by v1 api")
+	}
+	if w := ep.as("admin").do(http.MethodPost, "/service?namespace=team2&name=svc&node=n1&address=10.0.0.2:80", ""); w.Code != http.StatusOK {
+		t.Fatal("global password should access all namespaces:", w.Code)
+	}
+	if w := ep.as("pw1").do(http.MethodPost, "/service?namespace=team2&name=svc&node=n2&address=10.0.0.3:80", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("password of another namespace should be rejected:", w.Code)
+	}
+	if w := ep.as("pw1").do(http.MethodPost, "/service?name=svc&node=n2&address=10.0.0.3:80", ""); w.Code != http.StatusUnauthorized {
+		t.Fatal("namespace password should not access the default namespace:", w.Code)
+	}
+	if w := ep.as("admin").do(http.MethodGet, "/service?namespace=team3&name=svc", ""); w.Code != http.StatusNotFound {
+		t.Fatal("unknown namespace should not be found:", w.Code)
+	}
+
+	if items := query("/service?namespace=team1&name=svc", "pw1"); len(items) != 1 || items[0].Addr != "10.0.0.1:80" {
+		t.Fatal("unexpected services of team1:", items)
+	}
+	if items := query("/service?namespace=team2&name=svc", "pw2"); len(items) != 1 || items[0].Addr != "10.0.0.2:80" {
+		t.Fatal("unexpected services of team2:", items)
+	}
+	if items := query("/service?name=svc", "admin"); len(items) != 0 {
+		t.Fatal("services of namespaces should not be in the default namespace:", items)
+	}
+
+	// node ids are scoped by namespace
+	if w := ep.as("pw2").do(http.MethodDelete, "/service/node?namespace=team2&node=n1", ""); w.Code != http.StatusNoContent {
+		t.Fatal("deregister failed:", w.Code)
+	}
+	if items := query("/service?namespace=team1&name=svc", "pw1"); len(items) != 1 {
+		t.Fatal("services of team1 should be kept:", items)
+	}
+
+	if w := ep.as("pw1").do(http.MethodPut, "/dns/records/A/a.team1.example?namespace=team1", `{"value":"10.0.0.1"}`); w.Code != http.StatusOK {
+		t.Fatal("put record failed:", w.Code, w.Body.String())
+	}
+	if w := ep.as("pw1").do(http.MethodPut, "/dns/records/A/a.other.example?namespace=team1", `{"value":"10.0.0.1"}`); w.Code != http.StatusForbidden {
+		t.Fatal("record out of the namespace should be forbidden:", w.Code)
+	}
+	if w := ep.as("pw2").do(http.MethodGet, "/dns/records/A/a.team1.example?namespace=team2", ""); w.Code != http.StatusForbidden {
+		t.Fatal("record of another namespace should be forbidden:", w.Code)
+	}
+	if w := ep.as("admin").do(http.MethodGet, "/dns/records/A/a.team1.example", ""); w.Code != http.StatusOK {
+		t.Fatal("default namespace should access all the records:", w.Code)
+	}
+}
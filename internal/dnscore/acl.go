@@ -0,0 +1,68 @@
+package dnscore
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// CIDRList matches client addresses. Plain ip addresses are treated as single host networks.
+type CIDRList []*net.IPNet
+
+func ParseCIDRList(items []string) (CIDRList, error) {
+	var list CIDRList
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip address:%s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ipNet)
+	}
+	return list, nil
+}
+
+func (c CIDRList) Contains(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, v := range c {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessControl restricts clients by address. A nil list allows all the clients.
+type AccessControl struct {
+	// ManagedZones are the clients allowed to query local records
+	ManagedZones CIDRList
+	// Recursion are the clients allowed to resolve through the upstream servers
+	Recursion CIDRList
+}
+
+// Check marks the denied operations in the request context
+func (a *AccessControl) Check(ctx *RequestContext) {
+	ctx.ManagedZonesDenied = a.ManagedZones != nil && !a.ManagedZones.Contains(ctx.ClientIP)
+	ctx.RecursionDenied = a.Recursion != nil && !a.Recursion.Contains(ctx.ClientIP)
+}
+
+func newRefusedReply(m *dns.Msg, ctx *RequestContext, reason string) *dns.Msg {
+	ctx.AddTraceInfo("AccessControl-Refused:" + reason)
+	return new(dns.Msg).SetRcode(m, dns.RcodeRefused)
+}
@@ -0,0 +1,49 @@
+package dnscore
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAccessControl(t *testing.T) {
+	// the upstream is never contacted since recursion of all the test clients is denied
+	endpoint, err := NewDnsEndpoint("udp://127.0.0.1:0", testDnsStorage{"a.example.dns.": {"10.0.0.1"}}, []string{"127.0.0.1"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	managed, err := ParseCIDRList([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recursion, err := ParseCIDRList([]string{"172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint.ACL = &AccessControl{ManagedZones: managed, Recursion: recursion}
+
+	query := func(clientIP, name string) *dns.Msg {
+		ctx := NewRequestContext()
+		ctx.ClientIP = clientIP
+		return endpoint.ProcessDnsMsg(new(dns.Msg).SetQuestion(name, dns.TypeA), ctx)
+	}
+
+	if reply := query("10.1.2.3", "a.example.dns."); reply.Rcode != dns.RcodeSuccess || len(reply.Answer) != 1 {
+		t.Fatal("managed zone client should get local records:", reply)
+	}
+	if reply := query("10.1.2.3", "b.example.dns."); reply.Rcode != dns.RcodeRefused {
+		t.Fatal("recursion should be refused:", reply)
+	}
+	if reply := query("192.168.1.2", "a.example.dns."); reply.Rcode != dns.RcodeRefused {
+		t.Fatal("unknown client should be refused:", reply)
+	}
+
+	endpoint.Upstream = nil
+	if reply := query("172.16.0.1", "a.example.dns."); reply.Rcode != dns.RcodeRefused {
+		t.Fatal("managed zones should be refused:", reply)
+	}
+
+	if _, err := ParseCIDRList([]string{"10.0.0.0/33"}); err == nil {
+		t.Fatal("invalid cidr should fail")
+	}
+}
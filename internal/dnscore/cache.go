@@ -3,6 +3,7 @@ package dnscore
 import (
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +14,11 @@ import (
 type DnsCache interface {
 	Put(req, res *dns.Msg)
 	Get(req *dns.Msg) *dns.Msg
+	Purge()
 }
 
+// DnsMemCache caches responses by name and type.
+// Responses with EDNS client subnet scope are cached per subnet according to rfc7871.
 type DnsMemCache struct {
 	rwlock sync.RWMutex
 	cache  map[string]struct {
@@ -22,6 +26,8 @@ type DnsMemCache struct {
 		timeInSec int64
 		ttl       uint32
 	}
+	// scope prefix lengths of the subnet scoped entries by name, type and family, in descending order
+	scopes           map[string][]uint8
 	cleanUpJobTicker *time.Ticker
 }
 
@@ -32,6 +38,7 @@ func NewDnsMemCache() DnsCache {
 			timeInSec int64
 			ttl       uint32
 		}{},
+		scopes:           map[string][]uint8{},
 		cleanUpJobTicker: time.NewTicker(1 * time.Minute),
 	}
 	go cache.cleanupJob()
@@ -47,6 +54,17 @@ func (d *DnsMemCache) Put(req, res *dns.Msg) {
 
 	d.rwlock.Lock()
 	defer d.rwlock.Unlock()
+	if reqSubnet, resSubnet := findClientSubnet(req), findClientSubnet(res); reqSubnet != nil && resSubnet != nil && resSubnet.SourceScope > 0 {
+		// answers are valid for the network of the scope. scope longer than the source prefix is treated as the source prefix.
+		scope := min(resSubnet.SourceScope, reqSubnet.SourceNetmask)
+		scopesKey := subnetScopesKey(key, reqSubnet.Family)
+		if !slices.Contains(d.scopes[scopesKey], scope) {
+			scopes := append(d.scopes[scopesKey], scope)
+			slices.SortFunc(scopes, func(a, b uint8) int { return int(b) - int(a) })
+			d.scopes[scopesKey] = scopes
+		}
+		key = subnetCacheKey(key, reqSubnet, scope)
+	}
 	d.cache[key] = struct {
 		res       *dns.Msg
 		timeInSec int64
@@ -59,6 +77,21 @@ func (d *DnsMemCache) Get(req *dns.Msg) *dns.Msg {
 
 	d.rwlock.RLock()
 	defer d.rwlock.RUnlock()
+	// the most specific subnet scoped entry first, then the global one
+	if reqSubnet := findClientSubnet(req); reqSubnet != nil {
+		for _, scope := range d.scopes[subnetScopesKey(key, reqSubnet.Family)] {
+			if scope > reqSubnet.SourceNetmask {
+				continue
+			}
+			if res := d.getEntry(subnetCacheKey(key, reqSubnet, scope), req); res != nil {
+				return res
+			}
+		}
+	}
+	return d.getEntry(key, req)
+}
+
+func (d *DnsMemCache) getEntry(key string, req *dns.Msg) *dns.Msg {
 	r, ok := d.cache[key]
 	if !ok {
 		return nil
@@ -70,6 +103,17 @@ func (d *DnsMemCache) Get(req *dns.Msg) *dns.Msg {
 	}
 }
 
+func (d *DnsMemCache) Purge() {
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.cache = map[string]struct {
+		res       *dns.Msg
+		timeInSec int64
+		ttl       uint32
+	}{}
+	d.scopes = map[string][]uint8{}
+}
+
 func (d *DnsMemCache) cleanupJob() {
 	for {
 		t, ok := <-d.cleanUpJobTicker.C
@@ -107,3 +151,11 @@ func checkHasValidTTL(m *dns.Msg) (bool, uint32) {
 func cacheKey(m *dns.Msg) string {
 	return fmt.Sprint(strings.ToLower(dns.Fqdn(m.Question[0].Name)), "::", m.Question[0].Qtype)
 }
+
+func subnetScopesKey(key string, family uint16) string {
+	return fmt.Sprint(key, "::", family)
+}
+
+func subnetCacheKey(key string, subnet *dns.EDNS0_SUBNET, scope uint8) string {
+	return fmt.Sprint(key, "::", subnet.Family, "::", maskClientSubnet(subnet.Address, subnet.Family, scope).String(), "/", scope)
+}
@@ -10,7 +10,56 @@ type DnsRecordHandler interface {
 	HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns.Msg, error)
 }
 
+// DnsPolicy blocks or rewrites queries before they are resolved, e.g. response policy zones
+type DnsPolicy interface {
+	// ApplyPolicy returns the reply of the matched policy, or nil to continue resolution.
+	// ErrDoNotRespondResult is returned when the query should be dropped.
+	ApplyPolicy(m *dns.Msg, ctx *RequestContext) (*dns.Msg, error)
+}
+
 type DnsStorage interface {
 	ResolveDomain(domain string, domainType shared.DomainType) (string, error)
 	PutDomain(domain, resolve string, domainType shared.DomainType)
 }
+
+// DnsRRSetStorage is implemented by storages which are able to hold several records with the same name and type
+type DnsRRSetStorage interface {
+	ResolveDomainRRSet(domain string, domainType shared.DomainType) ([]string, error)
+}
+
+// ResolveDomainRRSet resolves all the records of the domain.
+// Storages without rrset support are treated as single record storages.
+func ResolveDomainRRSet(storage DnsStorage, domain string, domainType shared.DomainType) ([]string, error) {
+	if rrsetStorage, ok := storage.(DnsRRSetStorage); ok {
+		return rrsetStorage.ResolveDomainRRSet(domain, domainType)
+	}
+	r, err := storage.ResolveDomain(domain, domainType)
+	if err != nil {
+		return nil, err
+	}
+	return []string{r}, nil
+}
+
+// DnsTTLStorage is implemented by storages which carry the ttl of records, e.g. zone files
+type DnsTTLStorage interface {
+	ResolveDomainRRSetTTL(domain string, domainType shared.DomainType) ([]string, uint32, error)
+}
+
+// ResolveDomainRRSetTTL resolves all the records of the domain and the ttl of the rrset.
+// DefaultResponseTTL is used for storages without ttl support.
+func ResolveDomainRRSetTTL(storage DnsStorage, domain string, domainType shared.DomainType) ([]string, uint32, error) {
+	if ttlStorage, ok := storage.(DnsTTLStorage); ok {
+		return ttlStorage.ResolveDomainRRSetTTL(domain, domainType)
+	}
+	results, err := ResolveDomainRRSet(storage, domain, domainType)
+	return results, DefaultResponseTTL, err
+}
+
+// resolveHealthyRRSet resolves the records and omits unhealthy targets if health check is enabled
+func resolveHealthyRRSet(storage DnsStorage, health *HealthChecker, domain string, domainType shared.DomainType) ([]string, uint32, error) {
+	results, ttl, err := ResolveDomainRRSetTTL(storage, domain, domainType)
+	if err != nil || health == nil {
+		return results, ttl, err
+	}
+	return health.Filter(domain, domainType, results), ttl, nil
+}
@@ -0,0 +1,92 @@
+package dnscore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const DefaultDns64Prefix = "64:ff9b::/96"
+
+// Dns64Config synthesizes AAAA records from A records with the NAT64 prefix (rfc6147)
+type Dns64Config struct {
+	Prefix *net.IPNet
+}
+
+// NewDns64Config validates the prefix length against rfc6052: 32, 40, 48, 56, 64 or 96
+func NewDns64Config(prefix string) (*Dns64Config, error) {
+	if prefix == "" {
+		prefix = DefaultDns64Prefix
+	}
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, errors.New("dns64 prefix should be an ipv6 network:" + prefix)
+	}
+	switch ones, _ := ipNet.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, fmt.Errorf("unsupported dns64 prefix length:%d", ones)
+	}
+	return &Dns64Config{Prefix: ipNet}, nil
+}
+
+// Synthesize embeds the ipv4 address into the prefix. Bits 64 to 71 are kept zero as required by rfc6052.
+func (d *Dns64Config) Synthesize(ipv4 net.IP) net.IP {
+	v4 := ipv4.To4()
+	if v4 == nil {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, d.Prefix.IP.To16())
+	ones, _ := d.Prefix.Mask.Size()
+	pos := ones / 8
+	for _, b := range v4 {
+		if pos == 8 {
+			pos++
+		}
+		ip[pos] = b
+		pos++
+	}
+	return ip
+}
+
+// synthesizeReply converts the A records in the reply of the A query into AAAA records.
+// CNAME records are kept. nil is returned when there is no A record.
+func (d *Dns64Config) synthesizeReply(m, aReply *dns.Msg) *dns.Msg {
+	if aReply == nil || aReply.Rcode != dns.RcodeSuccess {
+		return nil
+	}
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	found := false
+	for _, rr := range aReply.Answer {
+		switch v := rr.(type) {
+		case *dns.A:
+			found = true
+			reply.Answer = append(reply.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: v.Hdr.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: v.Hdr.Ttl},
+				AAAA: d.Synthesize(v.A),
+			})
+		case *dns.CNAME:
+			reply.Answer = append(reply.Answer, dns.Copy(v))
+		}
+	}
+	if !found {
+		return nil
+	}
+	return reply
+}
+
+func hasAnswerOfType(m *dns.Msg, qtype uint16) bool {
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package dnscore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDns64Synthesize(t *testing.T) {
+	cases := map[string]string{
+		"64:ff9b::/96":          "64:ff9b::c000:221",
+		"2001:db8::/32":         "2001:db8:c000:221::",
+		"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+		"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+	}
+	for prefix, expected := range cases {
+		config, err := NewDns64Config(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := config.Synthesize(net.ParseIP("192.0.2.33")); !ip.Equal(net.ParseIP(expected)) {
+			t.Fatal("unexpected synthesized address:", prefix, ip)
+		}
+	}
+	if _, err := NewDns64Config("2001:db8::/33"); err == nil {
+		t.Fatal("invalid prefix length should fail")
+	}
+}
+
+func TestDns64LocalRecords(t *testing.T) {
+	endpoint, err := NewDnsEndpoint("udp://127.0.0.1:0", testDnsStorage{"a.example.dns.": {"192.0.2.33"}}, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _ := NewDns64Config("")
+	endpoint.EnableDns64(config)
+
+	reply := endpoint.ProcessDnsMsg(new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeAAAA), NewRequestContext())
+	if len(reply.Answer) != 1 || !reply.Answer[0].(*dns.AAAA).AAAA.Equal(net.ParseIP("64:ff9b::c000:221")) {
+		t.Fatal("unexpected reply:", reply)
+	}
+}
+
+func TestDns64SynthesizeReply(t *testing.T) {
+	config, _ := NewDns64Config("")
+	m := new(dns.Msg).SetQuestion("www.example.com.", dns.TypeAAAA)
+	aReply := new(dns.Msg).SetReply(new(dns.Msg).SetQuestion("www.example.com.", dns.TypeA))
+	aReply.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300}, Target: "cdn.example.net."},
+		&dns.A{Hdr: dns.RR_Header{Name: "cdn.example.net.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("192.0.2.1")},
+	}
+	reply := config.synthesizeReply(m, aReply)
+	if reply == nil || len(reply.Answer) != 2 || reply.Question[0].Qtype != dns.TypeAAAA {
+		t.Fatal("unexpected reply:", reply)
+	}
+	if aaaa := reply.Answer[1].(*dns.AAAA); aaaa.Hdr.Name != "cdn.example.net." || aaaa.Hdr.Ttl != 60 {
+		t.Fatal("unexpected record:", aaaa)
+	}
+	aReply.Answer = aReply.Answer[:1]
+	if reply := config.synthesizeReply(m, aReply); reply != nil {
+		t.Fatal("reply without A records should not be synthesized")
+	}
+}
@@ -0,0 +1,27 @@
+package dnscore
+
+import (
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+// testDnsStorage resolves A records of the domains, the first record for ResolveDomain
+type testDnsStorage map[string][]string
+
+func (t testDnsStorage) ResolveDomain(domain string, domainType shared.DomainType) (string, error) {
+	r, err := t.ResolveDomainRRSet(domain, domainType)
+	if err != nil {
+		return "", err
+	}
+	return r[0], nil
+}
+
+func (t testDnsStorage) ResolveDomainRRSet(domain string, domainType shared.DomainType) ([]string, error) {
+	if v, ok := t[domain]; ok && domainType == shared.DomainTypeA {
+		return v, nil
+	}
+	return nil, shared.ErrStorageNotFound
+}
+
+func (t testDnsStorage) PutDomain(domain, resolve string, domainType shared.DomainType) {
+	t[domain] = append(t[domain], resolve)
+}
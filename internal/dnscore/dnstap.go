@@ -0,0 +1,240 @@
+package dnscore
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+const DefaultDnstapBufferSize = 4096
+
+type DnstapStatus struct {
+	Output  string `json:"output"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// DnstapWriter emits dnstap frames to the output.
+// Frames are put into a bounded buffer without blocking. Frames are dropped once the buffer is full.
+type DnstapWriter struct {
+	output     dnstap.Output
+	outputName string
+	identity   []byte
+	version    []byte
+
+	buffer  chan []byte
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	done      chan struct{}
+}
+
+// NewDnstapWriter creates the writer to a unix socket or a file.
+// network: unix or file
+func NewDnstapWriter(network, location, identity, version string, bufferSize int) (*DnstapWriter, error) {
+	var output dnstap.Output
+	switch network {
+	case "unix":
+		o, err := dnstap.NewFrameStreamSockOutput(&net.UnixAddr{Name: location, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		output = o
+	case "file":
+		o, err := dnstap.NewFrameStreamOutputFromFilename(location)
+		if err != nil {
+			return nil, err
+		}
+		output = o
+	default:
+		return nil, errors.New("unknown dnstap output type:" + network)
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultDnstapBufferSize
+	}
+	return &DnstapWriter{
+		output:     output,
+		outputName: network + ":" + location,
+		identity:   []byte(identity),
+		version:    []byte(version),
+		buffer:     make(chan []byte, bufferSize),
+		closeCh:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (d *DnstapWriter) Start() {
+	go d.output.RunOutputLoop()
+	go func() {
+		defer close(d.done)
+		outCh := d.output.GetOutputChannel()
+		for {
+			select {
+			case <-d.closeCh:
+				// flush the frames in the buffer
+				for {
+					select {
+					case frame := <-d.buffer:
+						outCh <- frame
+						d.sent.Add(1)
+					default:
+						return
+					}
+				}
+			case frame := <-d.buffer:
+				outCh <- frame
+				d.sent.Add(1)
+			}
+		}
+	}()
+}
+
+// Close flushes the buffered frames and stops the writer
+func (d *DnstapWriter) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closeCh)
+		<-d.done
+		d.output.Close()
+	})
+}
+
+func (d *DnstapWriter) Status() DnstapStatus {
+	return DnstapStatus{
+		Output:  d.outputName,
+		Sent:    d.sent.Load(),
+		Dropped: d.dropped.Load(),
+	}
+}
+
+func (d *DnstapWriter) ClientQuery(ctx *RequestContext, req *dns.Msg) {
+	msg := d.newClientMessage(ctx, dnstap.Message_CLIENT_QUERY)
+	msg.QueryTimeSec, msg.QueryTimeNsec = dnstapTime(ctx.StartTime)
+	msg.QueryMessage = packDnsMsg(req)
+	d.write(msg, nil)
+}
+
+func (d *DnstapWriter) ClientResponse(ctx *RequestContext, req, reply *dns.Msg) {
+	d.write(d.newClientResponseMessage(ctx, req, reply), nil)
+}
+
+func (d *DnstapWriter) newClientResponseMessage(ctx *RequestContext, req, reply *dns.Msg) *dnstap.Message {
+	msg := d.newClientMessage(ctx, dnstap.Message_CLIENT_RESPONSE)
+	msg.QueryTimeSec, msg.QueryTimeNsec = dnstapTime(ctx.StartTime)
+	msg.ResponseTimeSec, msg.ResponseTimeNsec = dnstapTime(time.Now())
+	msg.QueryMessage = packDnsMsg(req)
+	msg.ResponseMessage = packDnsMsg(reply)
+	return msg
+}
+
+// ForwarderQuery records the query sent to the upstream server in host:port format
+func (d *DnstapWriter) ForwarderQuery(server string, queryTime time.Time, req *dns.Msg) {
+	msg := newForwarderMessage(server, dnstap.Message_FORWARDER_QUERY)
+	msg.QueryTimeSec, msg.QueryTimeNsec = dnstapTime(queryTime)
+	msg.QueryMessage = packDnsMsg(req)
+	d.write(msg, nil)
+}
+
+// ForwarderResponse records the response received from the upstream server in host:port format
+func (d *DnstapWriter) ForwarderResponse(server string, queryTime time.Time, req, reply *dns.Msg) {
+	msg := newForwarderMessage(server, dnstap.Message_FORWARDER_RESPONSE)
+	msg.QueryTimeSec, msg.QueryTimeNsec = dnstapTime(queryTime)
+	msg.ResponseTimeSec, msg.ResponseTimeNsec = dnstapTime(time.Now())
+	msg.QueryMessage = packDnsMsg(req)
+	msg.ResponseMessage = packDnsMsg(reply)
+	d.write(msg, nil)
+}
+
+// write puts the frame into the buffer. extra is the optional extra field of the frame.
+func (d *DnstapWriter) write(msg *dnstap.Message, extra []byte) {
+	frame, err := proto.Marshal(&dnstap.Dnstap{
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Identity: d.identity,
+		Version:  d.version,
+		Extra:    extra,
+		Message:  msg,
+	})
+	if err != nil {
+		logger.Error("marshal dnstap frame failed:", err)
+		return
+	}
+	select {
+	case d.buffer <- frame:
+	default:
+		d.dropped.Add(1)
+	}
+}
+
+func (d *DnstapWriter) newClientMessage(ctx *RequestContext, messageType dnstap.Message_Type) *dnstap.Message {
+	msg := &dnstap.Message{
+		Type: messageType.Enum(),
+	}
+	switch ctx.Protocol {
+	case ProtocolTcp:
+		msg.SocketProtocol = dnstap.SocketProtocol_TCP.Enum()
+	case ProtocolDoh:
+		msg.SocketProtocol = dnstap.SocketProtocol_DOH.Enum()
+	default:
+		msg.SocketProtocol = dnstap.SocketProtocol_UDP.Enum()
+	}
+	if ip := net.ParseIP(ctx.ClientIP); ip != nil {
+		msg.SocketFamily, msg.QueryAddress = dnstapAddress(ip)
+	}
+	if ctx.ClientPort != 0 {
+		port := uint32(ctx.ClientPort)
+		msg.QueryPort = &port
+	}
+	return msg
+}
+
+func newForwarderMessage(server string, messageType dnstap.Message_Type) *dnstap.Message {
+	msg := &dnstap.Message{
+		Type:           messageType.Enum(),
+		SocketProtocol: dnstap.SocketProtocol_UDP.Enum(),
+	}
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		return msg
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		msg.SocketFamily, msg.ResponseAddress = dnstapAddress(ip)
+	}
+	if port, err := strconv.ParseUint(portStr, 10, 16); err == nil {
+		p := uint32(port)
+		msg.ResponsePort = &p
+	}
+	return msg
+}
+
+func dnstapAddress(ip net.IP) (*dnstap.SocketFamily, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return dnstap.SocketFamily_INET.Enum(), ip4
+	}
+	return dnstap.SocketFamily_INET6.Enum(), ip.To16()
+}
+
+func dnstapTime(t time.Time) (*uint64, *uint32) {
+	sec := uint64(t.Unix())
+	nsec := uint32(t.Nanosecond())
+	return &sec, &nsec
+}
+
+func packDnsMsg(m *dns.Msg) []byte {
+	if m == nil {
+		return nil
+	}
+	data, err := m.Pack()
+	if err != nil {
+		logger.Error("pack dns message for dnstap failed:", err)
+		return nil
+	}
+	return data
+}
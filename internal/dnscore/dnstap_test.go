@@ -0,0 +1,84 @@
+package dnscore
+
+import (
+	"path/filepath"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDnstapFileOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dnstap.fstrm")
+	writer, err := NewDnstapWriter("file", file, "node1", "test", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Start()
+
+	ctx := NewRequestContext()
+	ctx.SetClientAddrString("192.168.1.10:53000")
+	ctx.Protocol = ProtocolTcp
+	req := new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeA)
+	reply := new(dns.Msg).SetReply(req)
+	writer.ClientQuery(ctx, req)
+	writer.ForwarderQuery("10.0.0.1:53", ctx.StartTime, req)
+	writer.ForwarderResponse("10.0.0.1:53", ctx.StartTime, req, reply)
+	writer.ClientResponse(ctx, req, reply)
+	writer.Close()
+
+	if status := writer.Status(); status.Sent != 4 || status.Dropped != 0 {
+		t.Fatal("unexpected status:", status)
+	}
+
+	input, err := dnstap.NewFrameStreamInputFromFilename(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan []byte, 8)
+	go func() {
+		input.ReadInto(ch)
+		close(ch)
+	}()
+	var types []dnstap.Message_Type
+	for frame := range ch {
+		msg := new(dnstap.Dnstap)
+		if err := proto.Unmarshal(frame, msg); err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.Identity) != "node1" {
+			t.Fatal("unexpected identity:", string(msg.Identity))
+		}
+		types = append(types, msg.Message.GetType())
+		if msg.Message.GetType() == dnstap.Message_CLIENT_QUERY {
+			if msg.Message.GetQueryPort() != 53000 || msg.Message.GetSocketProtocol() != dnstap.SocketProtocol_TCP {
+				t.Fatal("unexpected client query:", msg.Message)
+			}
+		}
+	}
+	expected := []dnstap.Message_Type{dnstap.Message_CLIENT_QUERY, dnstap.Message_FORWARDER_QUERY, dnstap.Message_FORWARDER_RESPONSE, dnstap.Message_CLIENT_RESPONSE}
+	if len(types) != len(expected) {
+		t.Fatal("unexpected frames:", types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Fatal("unexpected frames:", types)
+		}
+	}
+}
+
+func TestDnstapDropWhenBufferFull(t *testing.T) {
+	writer, err := NewDnstapWriter("file", filepath.Join(t.TempDir(), "dnstap.fstrm"), "node1", "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not started, so that the buffer is never consumed
+	req := new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeA)
+	for i := 0; i < 3; i++ {
+		writer.ClientQuery(NewRequestContext(), req)
+	}
+	if status := writer.Status(); status.Dropped != 2 {
+		t.Fatal("unexpected status:", status)
+	}
+}
@@ -0,0 +1,174 @@
+package dnscore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	EcsModePassthrough = "passthrough"
+	EcsModeStrip       = "strip"
+	EcsModeAdd         = "add"
+
+	DefaultEcsIPv4PrefixLen = 24
+	DefaultEcsIPv6PrefixLen = 56
+
+	ecsFamilyIPv4 = 1
+	ecsFamilyIPv6 = 2
+
+	defaultEdnsUdpSize = 1232
+)
+
+// EcsConfig controls EDNS Client Subnet (rfc7871) of the queries resolved through the upstream
+type EcsConfig struct {
+	// Mode: passthrough forwards the option of the client as it is,
+	// strip removes the option, add sends the subnet of the client address when the client doesn't send one.
+	Mode string
+	// max source prefix length sent to upstream. Longer prefixes from clients are truncated.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+func (e *EcsConfig) Validate() error {
+	switch e.Mode {
+	case EcsModePassthrough, EcsModeStrip, EcsModeAdd:
+	default:
+		return errors.New("unknown ecs mode:" + e.Mode)
+	}
+	if e.IPv4PrefixLen < 0 || e.IPv4PrefixLen > 32 || e.IPv6PrefixLen < 0 || e.IPv6PrefixLen > 128 {
+		return errors.New("invalid ecs prefix length")
+	}
+	return nil
+}
+
+// prepareRequest returns the query to resolve. The request is copied before modification.
+func (e *EcsConfig) prepareRequest(r *dns.Msg, ctx *RequestContext) *dns.Msg {
+	subnet := findClientSubnet(r)
+	switch e.Mode {
+	case EcsModeStrip:
+		if subnet != nil {
+			r = r.Copy()
+			removeClientSubnet(r)
+			ctx.AddTraceInfo("ECS-Stripped")
+		}
+	case EcsModeAdd:
+		if subnet != nil {
+			if subnet.SourceNetmask > e.maxPrefixLen(subnet.Family) {
+				r = r.Copy()
+				truncated := newClientSubnet(subnet.Address, subnet.Family, e.maxPrefixLen(subnet.Family))
+				setClientSubnet(r, truncated)
+				ctx.AddTraceInfo("ECS-Truncated:" + formatClientSubnet(truncated))
+			}
+			return r
+		}
+		ip := net.ParseIP(ctx.ClientIP)
+		if ip == nil {
+			return r
+		}
+		family := uint16(ecsFamilyIPv6)
+		if ip.To4() != nil {
+			family = ecsFamilyIPv4
+		}
+		r = r.Copy()
+		added := newClientSubnet(ip, family, e.maxPrefixLen(family))
+		setClientSubnet(r, added)
+		ctx.AddTraceInfo("ECS-Added:" + formatClientSubnet(added))
+	}
+	return r
+}
+
+func (e *EcsConfig) maxPrefixLen(family uint16) uint8 {
+	if family == ecsFamilyIPv4 {
+		if e.IPv4PrefixLen > 0 {
+			return uint8(e.IPv4PrefixLen)
+		}
+		return DefaultEcsIPv4PrefixLen
+	}
+	if e.IPv6PrefixLen > 0 {
+		return uint8(e.IPv6PrefixLen)
+	}
+	return DefaultEcsIPv6PrefixLen
+}
+
+// finalizeClientSubnet makes the option in the reply match the one sent by the client as required by rfc7871.
+// The option is removed when the client doesn't send one. The reply is copied before modification.
+func finalizeClientSubnet(reply *dns.Msg, clientSubnet *dns.EDNS0_SUBNET) *dns.Msg {
+	if reply == nil {
+		return nil
+	}
+	replySubnet := findClientSubnet(reply)
+	if replySubnet == nil {
+		return reply
+	}
+	reply = reply.Copy()
+	if clientSubnet == nil {
+		removeClientSubnet(reply)
+		return reply
+	}
+	echo := newClientSubnet(clientSubnet.Address, clientSubnet.Family, clientSubnet.SourceNetmask)
+	echo.SourceScope = min(replySubnet.SourceScope, clientSubnet.SourceNetmask)
+	setClientSubnet(reply, echo)
+	return reply
+}
+
+func newClientSubnet(ip net.IP, family uint16, prefixLen uint8) *dns.EDNS0_SUBNET {
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: prefixLen,
+		Address:       maskClientSubnet(ip, family, prefixLen),
+	}
+}
+
+func maskClientSubnet(ip net.IP, family uint16, prefixLen uint8) net.IP {
+	if family == ecsFamilyIPv4 {
+		return ip.To4().Mask(net.CIDRMask(int(prefixLen), 32))
+	}
+	return ip.To16().Mask(net.CIDRMask(int(prefixLen), 128))
+}
+
+func findClientSubnet(m *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, option := range opt.Option {
+		if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+			return subnet
+		}
+	}
+	return nil
+}
+
+func removeClientSubnet(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if _, ok := option.(*dns.EDNS0_SUBNET); !ok {
+			options = append(options, option)
+		}
+	}
+	opt.Option = options
+}
+
+func setClientSubnet(m *dns.Msg, subnet *dns.EDNS0_SUBNET) {
+	if m.IsEdns0() == nil {
+		m.SetEdns0(defaultEdnsUdpSize, false)
+	}
+	removeClientSubnet(m)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, subnet)
+}
+
+func formatClientSubnet(subnet *dns.EDNS0_SUBNET) string {
+	if subnet == nil {
+		return ""
+	}
+	return fmt.Sprint(subnet.Address.String(), "/", subnet.SourceNetmask)
+}
@@ -0,0 +1,101 @@
+package dnscore
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newEcsQuery(name, subnet string, prefixLen uint8) *dns.Msg {
+	m := new(dns.Msg).SetQuestion(name, dns.TypeA)
+	if subnet != "" {
+		setClientSubnet(m, newClientSubnet(net.ParseIP(subnet), ecsFamilyIPv4, prefixLen))
+	}
+	return m
+}
+
+func newEcsReply(req *dns.Msg, ip string, scope uint8) *dns.Msg {
+	reply := new(dns.Msg).SetReply(req)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(ip),
+	})
+	if subnet := findClientSubnet(req); subnet != nil {
+		echo := newClientSubnet(subnet.Address, subnet.Family, subnet.SourceNetmask)
+		echo.SourceScope = scope
+		setClientSubnet(reply, echo)
+	}
+	return reply
+}
+
+func TestEcsPrepareRequest(t *testing.T) {
+	ctx := NewRequestContext()
+	ctx.ClientIP = "192.168.10.20"
+
+	add := &EcsConfig{Mode: EcsModeAdd}
+	r := add.prepareRequest(newEcsQuery("a.example.com.", "", 0), ctx)
+	if subnet := findClientSubnet(r); subnet == nil || formatClientSubnet(subnet) != "192.168.10.0/24" {
+		t.Fatal("subnet should be added:", formatClientSubnet(subnet))
+	}
+	orig := newEcsQuery("a.example.com.", "10.1.2.3", 32)
+	r = add.prepareRequest(orig, ctx)
+	if formatClientSubnet(findClientSubnet(r)) != "10.1.2.0/24" || formatClientSubnet(findClientSubnet(orig)) != "10.1.2.3/32" {
+		t.Fatal("subnet should be truncated on a copy:", formatClientSubnet(findClientSubnet(r)))
+	}
+
+	strip := &EcsConfig{Mode: EcsModeStrip}
+	if r := strip.prepareRequest(newEcsQuery("a.example.com.", "10.1.2.0", 24), ctx); findClientSubnet(r) != nil {
+		t.Fatal("subnet should be stripped")
+	}
+}
+
+func TestEcsFinalizeReply(t *testing.T) {
+	clientReq := newEcsQuery("a.example.com.", "10.1.2.3", 32)
+	upstreamReq := newEcsQuery("a.example.com.", "10.1.2.0", 24)
+	reply := newEcsReply(upstreamReq, "1.1.1.1", 24)
+
+	final := finalizeClientSubnet(reply, findClientSubnet(clientReq))
+	if subnet := findClientSubnet(final); formatClientSubnet(subnet) != "10.1.2.3/32" || subnet.SourceScope != 24 {
+		t.Fatal("subnet of the client should be echoed:", formatClientSubnet(subnet))
+	}
+	if final = finalizeClientSubnet(reply, nil); findClientSubnet(final) != nil || findClientSubnet(reply) == nil {
+		t.Fatal("subnet should be removed on a copy")
+	}
+}
+
+func TestCacheScopedBySubnet(t *testing.T) {
+	cache := NewDnsMemCache()
+
+	req1 := newEcsQuery("a.example.com.", "10.1.2.0", 24)
+	cache.Put(req1, newEcsReply(req1, "1.1.1.1", 16))
+	req2 := newEcsQuery("a.example.com.", "10.2.2.0", 24)
+	cache.Put(req2, newEcsReply(req2, "2.2.2.2", 16))
+
+	get := func(req *dns.Msg) string {
+		res := cache.Get(req)
+		if res == nil {
+			return ""
+		}
+		return res.Answer[0].(*dns.A).A.String()
+	}
+	if v := get(newEcsQuery("a.example.com.", "10.1.99.0", 24)); v != "1.1.1.1" {
+		t.Fatal("same /16 scope should hit:", v)
+	}
+	if v := get(newEcsQuery("a.example.com.", "10.2.3.0", 24)); v != "2.2.2.2" {
+		t.Fatal("same /16 scope should hit:", v)
+	}
+	if v := get(newEcsQuery("a.example.com.", "10.3.3.0", 24)); v != "" {
+		t.Fatal("other subnet should miss:", v)
+	}
+	if v := get(newEcsQuery("a.example.com.", "10.1.0.0", 8)); v != "" {
+		t.Fatal("shorter source prefix than scope should miss:", v)
+	}
+
+	// global answers with scope 0 are shared by all the clients
+	req3 := newEcsQuery("b.example.com.", "10.1.2.0", 24)
+	cache.Put(req3, newEcsReply(req3, "3.3.3.3", 0))
+	if v := get(newEcsQuery("b.example.com.", "", 0)); v != "3.3.3.3" {
+		t.Fatal("global answer should hit:", v)
+	}
+}
@@ -3,6 +3,7 @@ package dnscore
 import (
 	"errors"
 	"net"
+	"strings"
 
 	"github.com/miekg/dns"
 
@@ -13,6 +14,12 @@ type RecordAAAAHandler struct {
 	*ParentRecordHandler
 	DnsStorage
 
+	// Health is nil when no health check is configured
+	Health *HealthChecker
+
+	// Dns64 is nil when dns64 is disabled
+	Dns64 *Dns64Config
+
 	debugOutput bool
 }
 
@@ -30,30 +37,39 @@ func (r *RecordAAAAHandler) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dn
 		logger.Debug("[RecordAAAAHandler] domain:", domain)
 	}
 
-	ctx.AddTraceInfo("RecordAAAAHandler")
-	result, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypeAAAA)
+	ctx.EnterHandler("RecordAAAAHandler")
+	results, ttl, err := resolveHealthyRRSet(r.DnsStorage, r.Health, domain, shared.DomainTypeAAAA)
 	if errors.Is(err, shared.ErrStorageNotFound) {
 		// Need to check if the A record with the same name exists
 		// In this case, empty AAAA or no AAAA response will be generated.
 		// This will break AAAA resolution flow if the AAAA record is provided by external.
 		// But since the domain is managed by this software, A and AAAA records have to be configured together at all time.
 		if r.checkIfARecordExists(domain) {
+			if r.Dns64 != nil {
+				return r.synthesizeFromLocal(m, ctx)
+			}
 			return NotFoundUpstreamDns{}.HandleQuestion(m, ctx)
 		}
 
-		return r.ParentRecordHandler.HandleQuestion(m, ctx)
+		res, err := r.ParentRecordHandler.HandleQuestion(m, ctx)
+		if err != nil || r.Dns64 == nil {
+			return res, err
+		}
+		return r.synthesizeFromUpstream(m, ctx, res), nil
 	} else if err != nil {
 		return nil, err
 	}
-	ctx.AddTraceInfo("RecordAAAAHandler->" + result)
+	ctx.AddTraceInfo("RecordAAAAHandler->" + strings.Join(results, ","))
 
 	reply := new(dns.Msg)
 	reply.SetReply(m)
-	rr := &dns.AAAA{
-		Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: DefaultResponseTTL},
-		AAAA: net.ParseIP(result),
+	for _, v := range results {
+		rr := &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: net.ParseIP(v),
+		}
+		reply.Answer = append(reply.Answer, rr)
 	}
-	reply.Answer = append(reply.Answer, rr)
 	return reply, nil
 }
 
@@ -61,3 +77,41 @@ func (r *RecordAAAAHandler) checkIfARecordExists(domain string) bool {
 	_, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypeA)
 	return err == nil
 }
+
+func (r *RecordAAAAHandler) synthesizeFromLocal(m *dns.Msg, ctx *RequestContext) (*dns.Msg, error) {
+	domain := m.Question[0].Name
+	results, ttl, err := resolveHealthyRRSet(r.DnsStorage, r.Health, domain, shared.DomainTypeA)
+	if err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	for _, v := range results {
+		reply.Answer = append(reply.Answer, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: r.Dns64.Synthesize(net.ParseIP(v)),
+		})
+	}
+	ctx.AddTraceInfoWithDnsAnswersIfNoError("RecordAAAAHandler-Dns64->", reply, nil)
+	return reply, nil
+}
+
+// synthesizeFromUpstream queries A records when the AAAA response has no answer
+func (r *RecordAAAAHandler) synthesizeFromUpstream(m *dns.Msg, ctx *RequestContext, res *dns.Msg) *dns.Msg {
+	if res == nil || res.Rcode != dns.RcodeSuccess || hasAnswerOfType(res, dns.TypeAAAA) {
+		return res
+	}
+	aQuery := m.Copy()
+	aQuery.Question[0].Qtype = dns.TypeA
+	aReply, err := r.ParentRecordHandler.HandleQuestion(aQuery, ctx)
+	if err != nil {
+		logger.Warn("dns64 query A records failed:", err)
+		return res
+	}
+	reply := r.Dns64.synthesizeReply(m, aReply)
+	if reply == nil {
+		return res
+	}
+	ctx.AddTraceInfoWithDnsAnswersIfNoError("RecordAAAAHandler-Dns64->", reply, nil)
+	return reply
+}
@@ -3,6 +3,7 @@ package dnscore
 import (
 	"errors"
 	"net"
+	"strings"
 
 	"github.com/miekg/dns"
 
@@ -24,6 +25,9 @@ type RecordAHandler struct {
 	*ParentRecordHandler
 	DnsStorage
 
+	// Health is nil when no health check is configured
+	Health *HealthChecker
+
 	debugOutput bool
 }
 
@@ -41,21 +45,23 @@ func (r *RecordAHandler) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns.M
 		logger.Debug("[RecordAHandler] domain:", domain)
 	}
 
-	ctx.AddTraceInfo("RecordAHandler")
-	result, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypeA)
+	ctx.EnterHandler("RecordAHandler")
+	results, ttl, err := resolveHealthyRRSet(r.DnsStorage, r.Health, domain, shared.DomainTypeA)
 	if errors.Is(err, shared.ErrStorageNotFound) {
 		return r.ParentRecordHandler.HandleQuestion(m, ctx)
 	} else if err != nil {
 		return nil, err
 	}
-	ctx.AddTraceInfo("RecordAHandler->" + result)
+	ctx.AddTraceInfo("RecordAHandler->" + strings.Join(results, ","))
 
 	reply := new(dns.Msg)
 	reply.SetReply(m)
-	rr := &dns.A{
-		Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: DefaultResponseTTL},
-		A:   net.ParseIP(result),
+	for _, v := range results {
+		rr := &dns.A{
+			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.ParseIP(v),
+		}
+		reply.Answer = append(reply.Answer, rr)
 	}
-	reply.Answer = append(reply.Answer, rr)
 	return reply, nil
 }
@@ -2,6 +2,7 @@ package dnscore
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/miekg/dns"
 
@@ -29,21 +30,23 @@ func (r *RecordPtrHandler) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns
 		logger.Debug("[RecordPtrHandler] domain:", domain)
 	}
 
-	ctx.AddTraceInfo("RecordPtrHandler")
-	result, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypePtr)
+	ctx.EnterHandler("RecordPtrHandler")
+	results, ttl, err := ResolveDomainRRSetTTL(r.DnsStorage, domain, shared.DomainTypePtr)
 	if errors.Is(err, shared.ErrStorageNotFound) {
 		return r.ParentRecordHandler.HandleQuestion(m, ctx)
 	} else if err != nil {
 		return nil, err
 	}
-	ctx.AddTraceInfo("RecordPtrHandler->" + result)
+	ctx.AddTraceInfo("RecordPtrHandler->" + strings.Join(results, ","))
 
 	reply := new(dns.Msg)
 	reply.SetReply(m)
-	rr := &dns.PTR{
-		Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: DefaultResponseTTL},
-		Ptr: result,
+	for _, v := range results {
+		rr := &dns.PTR{
+			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+			Ptr: v,
+		}
+		reply.Answer = append(reply.Answer, rr)
 	}
-	reply.Answer = append(reply.Answer, rr)
 	return reply, nil
 }
@@ -3,16 +3,28 @@ package dnscore
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 
 	"github.com/miekg/dns"
 
 	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
 )
 
+// SrvRecordValue is the stored format of SRV records
+type SrvRecordValue struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
 type RecordSRVHandler struct {
 	*ParentRecordHandler
 	DnsStorage
 
+	// Health is nil when no health check is configured
+	Health *HealthChecker
+
 	debugOutput bool
 }
 
@@ -30,34 +42,31 @@ func (r *RecordSRVHandler) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns
 		logger.Debug("[RecordSRVHandler] domain:", domain)
 	}
 
-	ctx.AddTraceInfo("RecordSRVHandler")
-	result, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypeSrv)
+	ctx.EnterHandler("RecordSRVHandler")
+	results, ttl, err := resolveHealthyRRSet(r.DnsStorage, r.Health, domain, shared.DomainTypeSrv)
 	if errors.Is(err, shared.ErrStorageNotFound) {
 		return r.ParentRecordHandler.HandleQuestion(m, ctx)
 	} else if err != nil {
 		return nil, err
 	}
-	ctx.AddTraceInfo("RecordSRVHandler->" + result)
-
-	var srvData = struct {
-		Priority uint16 `json:"priority"`
-		Weight   uint16 `json:"weight"`
-		Port     uint16 `json:"port"`
-		Target   string `json:"target"`
-	}{}
-	if err := json.Unmarshal([]byte(result), &srvData); err != nil {
-		return nil, err
-	}
+	ctx.AddTraceInfo("RecordSRVHandler->" + strings.Join(results, ","))
 
 	reply := new(dns.Msg)
 	reply.SetReply(m)
-	rr := &dns.SRV{
-		Hdr:      dns.RR_Header{Name: domain, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: DefaultResponseTTL},
-		Priority: srvData.Priority,
-		Weight:   srvData.Weight,
-		Port:     srvData.Port,
-		Target:   dns.Fqdn(srvData.Target),
+	for _, result := range results {
+		var srvData = SrvRecordValue{}
+		if err := json.Unmarshal([]byte(result), &srvData); err != nil {
+			return nil, err
+		}
+
+		rr := &dns.SRV{
+			Hdr:      dns.RR_Header{Name: domain, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Priority: srvData.Priority,
+			Weight:   srvData.Weight,
+			Port:     srvData.Port,
+			Target:   dns.Fqdn(srvData.Target),
+		}
+		reply.Answer = append(reply.Answer, rr)
 	}
-	reply.Answer = append(reply.Answer, rr)
 	return reply, nil
 }
@@ -2,6 +2,7 @@ package dnscore
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/miekg/dns"
 
@@ -29,21 +30,23 @@ func (r *RecordTxtHandler) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns
 		logger.Debug("[RecordTxtHandler] domain:", domain)
 	}
 
-	ctx.AddTraceInfo("RecordTxtHandler")
-	result, err := r.DnsStorage.ResolveDomain(domain, shared.DomainTypeTxt)
+	ctx.EnterHandler("RecordTxtHandler")
+	results, ttl, err := ResolveDomainRRSetTTL(r.DnsStorage, domain, shared.DomainTypeTxt)
 	if errors.Is(err, shared.ErrStorageNotFound) {
 		return r.ParentRecordHandler.HandleQuestion(m, ctx)
 	} else if err != nil {
 		return nil, err
 	}
-	ctx.AddTraceInfo("RecordTxtHandler->" + result)
+	ctx.AddTraceInfo("RecordTxtHandler->" + strings.Join(results, ","))
 
 	reply := new(dns.Msg)
 	reply.SetReply(m)
-	rr := &dns.TXT{
-		Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: DefaultResponseTTL},
-		Txt: []string{result},
+	for _, v := range results {
+		rr := &dns.TXT{
+			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+			Txt: []string{v},
+		}
+		reply.Answer = append(reply.Answer, rr)
 	}
-	reply.Answer = append(reply.Answer, rr)
 	return reply, nil
 }
@@ -0,0 +1,356 @@
+package dnscore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const (
+	HealthCheckTcp  = "tcp"
+	HealthCheckHttp = "http"
+	HealthCheckDns  = "dns"
+
+	DefaultHealthCheckInterval = 10
+	DefaultHealthCheckTimeout  = 3
+	DefaultHealthCheckHttpPort = 80
+	DefaultHealthCheckDnsPort  = 53
+)
+
+// HealthCheckConfig probes all the targets of the records with the domain and type
+type HealthCheckConfig struct {
+	Domain string
+	// Type is one of A, AAAA and SRV
+	Type shared.DomainType
+	// Check is one of tcp, http and dns
+	Check string
+	// Port to probe. SRV records use the port of the record when it is zero.
+	Port int
+	// Path of http checks. A target is healthy when the status code is less than 400.
+	Path string
+	// ProbeDomain is the question of dns checks. A target is healthy when it answers NOERROR or NXDOMAIN.
+	ProbeDomain string
+	// Interval and Timeout in seconds
+	Interval int
+	Timeout  int
+}
+
+func (c *HealthCheckConfig) Validate() error {
+	if c.Domain == "" {
+		return errors.New("health check domain is required")
+	}
+	switch c.Type {
+	case shared.DomainTypeA, shared.DomainTypeAAAA, shared.DomainTypeSrv:
+	default:
+		return errors.New("health check only supports A, AAAA and SRV records:" + c.Domain)
+	}
+	switch c.Check {
+	case HealthCheckTcp:
+		if c.Port == 0 && c.Type != shared.DomainTypeSrv {
+			return errors.New("tcp health check requires port:" + c.Domain)
+		}
+	case HealthCheckHttp, HealthCheckDns:
+	default:
+		return errors.New("unknown health check type:" + c.Check)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return errors.New("invalid health check port:" + c.Domain)
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return errors.New("negative health check interval or timeout:" + c.Domain)
+	}
+	return nil
+}
+
+type healthCheckKey struct {
+	domain     string
+	domainType shared.DomainType
+}
+
+type targetHealth struct {
+	address   string
+	healthy   bool
+	lastCheck time.Time
+	lastError string
+}
+
+type healthCheck struct {
+	config  *HealthCheckConfig
+	timeout time.Duration
+	// targets by record value
+	targets map[string]*targetHealth
+}
+
+type TargetHealthStatus struct {
+	Value     string    `json:"value"`
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type HealthCheckStatus struct {
+	Domain  string               `json:"domain"`
+	Type    string               `json:"type"`
+	Check   string               `json:"check"`
+	Targets []TargetHealthStatus `json:"targets"`
+}
+
+// HealthChecker actively probes record targets and omits unhealthy targets from answers.
+// All the targets are returned when every one of them is unhealthy.
+type HealthChecker struct {
+	storage DnsStorage
+	// ChangeListener is invoked when the health of any target changes, e.g. to purge cached responses
+	ChangeListener func()
+
+	lock   sync.RWMutex
+	checks map[healthCheckKey]*healthCheck
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewHealthChecker(storage DnsStorage, configs []*HealthCheckConfig) (*HealthChecker, error) {
+	checker := &HealthChecker{
+		storage: storage,
+		checks:  map[healthCheckKey]*healthCheck{},
+		stopCh:  make(chan struct{}),
+	}
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		c := *config
+		c.Domain = dns.Fqdn(strings.ToLower(c.Domain))
+		if c.Interval == 0 {
+			c.Interval = DefaultHealthCheckInterval
+		}
+		if c.Timeout == 0 {
+			c.Timeout = DefaultHealthCheckTimeout
+		}
+		key := healthCheckKey{domain: c.Domain, domainType: c.Type}
+		if _, ok := checker.checks[key]; ok {
+			return nil, errors.New("duplicated health check:" + c.Domain + " " + c.Type.String())
+		}
+		checker.checks[key] = &healthCheck{
+			config:  &c,
+			timeout: time.Duration(c.Timeout) * time.Second,
+			targets: map[string]*targetHealth{},
+		}
+	}
+	return checker, nil
+}
+
+// Start probes all the targets immediately and then periodically in background without blocking.
+// Targets are treated as healthy until their first probe completes.
+func (h *HealthChecker) Start() {
+	for key, check := range h.checks {
+		h.wg.Add(1)
+		go h.loop(key, check)
+	}
+}
+
+func (h *HealthChecker) Stop() {
+	close(h.stopCh)
+	h.wg.Wait()
+}
+
+func (h *HealthChecker) loop(key healthCheckKey, check *healthCheck) {
+	defer h.wg.Done()
+	h.probe(key, check)
+	ticker := time.NewTicker(time.Duration(check.config.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+			h.probe(key, check)
+		}
+	}
+}
+
+// probe checks the current records since records may change at runtime
+func (h *HealthChecker) probe(key healthCheckKey, check *healthCheck) {
+	values, err := ResolveDomainRRSet(h.storage, key.domain, key.domainType)
+	if err != nil && !errors.Is(err, shared.ErrStorageNotFound) {
+		logger.Warn("health check resolve records failed:", key.domain, err)
+		return
+	}
+
+	results := make([]*targetHealth, len(values))
+	wg := sync.WaitGroup{}
+	for i, value := range values {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := &targetHealth{lastCheck: time.Now()}
+			address, err := check.address(value)
+			if err == nil {
+				result.address = address
+				err = check.run(address)
+			}
+			if err != nil {
+				result.lastError = err.Error()
+			} else {
+				result.healthy = true
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	changed := false
+	h.lock.Lock()
+	targets := make(map[string]*targetHealth, len(values))
+	for i, value := range values {
+		if prev, ok := check.targets[value]; ok && prev.healthy != results[i].healthy {
+			changed = true
+			logger.Info("health of target changed. domain:", key.domain, "target:", value, "healthy:", results[i].healthy, "error:", results[i].lastError)
+		} else if !ok && !results[i].healthy {
+			changed = true
+			logger.Info("target is unhealthy. domain:", key.domain, "target:", value, "error:", results[i].lastError)
+		}
+		targets[value] = results[i]
+	}
+	check.targets = targets
+	h.lock.Unlock()
+
+	if changed && h.ChangeListener != nil {
+		h.ChangeListener()
+	}
+}
+
+func (c *healthCheck) address(value string) (string, error) {
+	port := c.config.Port
+	host := value
+	if c.config.Type == shared.DomainTypeSrv {
+		var srvData = SrvRecordValue{}
+		if err := json.Unmarshal([]byte(value), &srvData); err != nil {
+			return "", err
+		}
+		host = strings.TrimSuffix(srvData.Target, ".")
+		if port == 0 {
+			port = int(srvData.Port)
+		}
+	}
+	if port == 0 {
+		switch c.config.Check {
+		case HealthCheckHttp:
+			port = DefaultHealthCheckHttpPort
+		case HealthCheckDns:
+			port = DefaultHealthCheckDnsPort
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+func (c *healthCheck) run(address string) error {
+	switch c.config.Check {
+	case HealthCheckTcp:
+		conn, err := net.DialTimeout("tcp", address, c.timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case HealthCheckHttp:
+		client := &http.Client{
+			Timeout: c.timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		resp, err := client.Get("http://" + address + "/" + strings.TrimPrefix(c.config.Path, "/"))
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("http status code:%d", resp.StatusCode)
+		}
+		return nil
+	case HealthCheckDns:
+		probeDomain := c.config.ProbeDomain
+		if probeDomain == "" {
+			probeDomain = c.config.Domain
+		}
+		client := &dns.Client{Timeout: c.timeout}
+		reply, _, err := client.Exchange(new(dns.Msg).SetQuestion(dns.Fqdn(probeDomain), dns.TypeA), address)
+		if err != nil {
+			return err
+		}
+		if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+			return errors.New("dns rcode:" + dns.RcodeToString[reply.Rcode])
+		}
+		return nil
+	default:
+		return errors.New("unknown health check type:" + c.config.Check)
+	}
+}
+
+// Filter removes unhealthy values of the records. Values not probed yet are treated as healthy.
+// All the values are returned when none of them is healthy.
+func (h *HealthChecker) Filter(domain string, domainType shared.DomainType, values []string) []string {
+	check, ok := h.checks[healthCheckKey{domain: strings.ToLower(domain), domainType: domainType}]
+	if !ok {
+		return values
+	}
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var healthy []string
+	for _, v := range values {
+		if target, ok := check.targets[v]; !ok || target.healthy {
+			healthy = append(healthy, v)
+		}
+	}
+	if len(healthy) == 0 {
+		return values
+	}
+	return healthy
+}
+
+func (h *HealthChecker) Status() []HealthCheckStatus {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	var r []HealthCheckStatus
+	for key, check := range h.checks {
+		status := HealthCheckStatus{
+			Domain:  key.domain,
+			Type:    key.domainType.String(),
+			Check:   check.config.Check,
+			Targets: []TargetHealthStatus{},
+		}
+		for value, target := range check.targets {
+			status.Targets = append(status.Targets, TargetHealthStatus{
+				Value:     value,
+				Address:   target.address,
+				Healthy:   target.healthy,
+				LastCheck: target.lastCheck,
+				LastError: target.lastError,
+			})
+		}
+		sort.Slice(status.Targets, func(i, j int) bool {
+			return status.Targets[i].Value < status.Targets[j].Value
+		})
+		r = append(r, status)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Domain != r[j].Domain {
+			return r[i].Domain < r[j].Domain
+		}
+		return r[i].Type < r[j].Type
+	})
+	return r
+}
@@ -0,0 +1,123 @@
+package dnscore
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func TestHealthCheckOmitsUnhealthyTargets(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	storage := testDnsStorage{
+		"svc.example.dns.":  {"127.0.0.1", "127.0.0.2"},
+		"down.example.dns.": {"127.0.0.2", "127.0.0.3"},
+	}
+	endpoint, err := NewDnsEndpoint("udp://127.0.0.1:0", storage, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker, err := NewHealthChecker(storage, []*HealthCheckConfig{
+		{Domain: "svc.example.dns", Type: shared.DomainTypeA, Check: HealthCheckTcp, Port: port, Timeout: 1},
+		{Domain: "down.example.dns", Type: shared.DomainTypeA, Check: HealthCheckTcp, Port: port, Timeout: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged := 0
+	checker.ChangeListener = func() { purged++ }
+	for key, check := range checker.checks {
+		checker.probe(key, check)
+	}
+	endpoint.EnableHealthCheck(checker)
+	if purged != 2 {
+		t.Fatal("change listener should be invoked when targets are unhealthy:", purged)
+	}
+
+	reply := endpoint.ProcessDnsMsg(new(dns.Msg).SetQuestion("svc.example.dns.", dns.TypeA), NewRequestContext())
+	if len(reply.Answer) != 1 || !reply.Answer[0].(*dns.A).A.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal("unhealthy target should be omitted:", reply.Answer)
+	}
+	// fallback to all the targets
+	reply = endpoint.ProcessDnsMsg(new(dns.Msg).SetQuestion("down.example.dns.", dns.TypeA), NewRequestContext())
+	if len(reply.Answer) != 2 {
+		t.Fatal("all the targets should be returned when every one is down:", reply.Answer)
+	}
+
+	status := checker.Status()
+	if len(status) != 2 || status[1].Domain != "svc.example.dns." || len(status[1].Targets) != 2 ||
+		!status[1].Targets[0].Healthy || status[1].Targets[1].Healthy || status[1].Targets[1].LastError == "" {
+		t.Fatal("unexpected status:", status)
+	}
+}
+
+func TestHealthCheckConfigValidate(t *testing.T) {
+	for _, c := range []*HealthCheckConfig{
+		{Domain: "a.example.dns", Type: shared.DomainTypeTxt, Check: HealthCheckHttp},
+		{Domain: "a.example.dns", Type: shared.DomainTypeA, Check: HealthCheckTcp},
+		{Domain: "a.example.dns", Type: shared.DomainTypeA, Check: "icmp"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatal("invalid config should fail:", c)
+		}
+	}
+	if _, err := NewHealthChecker(nil, []*HealthCheckConfig{
+		{Domain: "a.example.dns", Type: shared.DomainTypeA, Check: HealthCheckHttp},
+		{Domain: "A.example.dns.", Type: shared.DomainTypeA, Check: HealthCheckDns},
+	}); err == nil {
+		t.Fatal("duplicated health checks should fail")
+	}
+}
+
+func TestHealthCheckStartWithoutBlocking(t *testing.T) {
+	probed := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(probed)
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	_, portStr, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	storage := testDnsStorage{"svc.example.dns.": {"127.0.0.1"}}
+	checker, err := NewHealthChecker(storage, []*HealthCheckConfig{
+		{Domain: "svc.example.dns", Type: shared.DomainTypeA, Check: HealthCheckHttp, Port: port, Timeout: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker.Start()
+	defer checker.Stop()
+
+	// the first probe is still running
+	<-probed
+	if r := checker.Filter("svc.example.dns.", shared.DomainTypeA, storage["svc.example.dns."]); len(r) != 1 {
+		t.Fatal("targets should be healthy until the first probe completes:", r)
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := checker.Status()
+		if len(status[0].Targets) == 1 && !status[0].Targets[0].Healthy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first probe result not recorded:", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,118 @@
+package dnscore
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"time"
+
+	"github.com/FimGroup/logging"
+	"github.com/miekg/dns"
+)
+
+// QueryLogger records every processed dns query
+type QueryLogger interface {
+	LogQuery(ctx *RequestContext, req, reply *dns.Msg)
+}
+
+type QueryLogEntry struct {
+	Time         time.Time `json:"time"`
+	ClientIP     string    `json:"client_ip"`
+	Protocol     string    `json:"protocol"`
+	ClientSubnet string    `json:"client_subnet,omitempty"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Rcode        string    `json:"rcode"`
+	AnswerCount  int       `json:"answer_count"`
+	Handlers     []string  `json:"handlers"`
+	CacheHit     bool      `json:"cache_hit"`
+	LatencyUs    int64     `json:"latency_us"`
+}
+
+// NewQueryLogEntry collects the query log fields. Rcode is empty when no reply is sent.
+func NewQueryLogEntry(ctx *RequestContext, req, reply *dns.Msg) *QueryLogEntry {
+	entry := &QueryLogEntry{
+		Time:         ctx.StartTime,
+		ClientIP:     ctx.ClientIP,
+		Protocol:     ctx.Protocol,
+		ClientSubnet: ctx.ClientSubnet,
+		Handlers:     ctx.HandlerPaths(),
+		CacheHit:     ctx.CacheHit,
+		LatencyUs:    time.Since(ctx.StartTime).Microseconds(),
+	}
+	if len(req.Question) > 0 {
+		entry.Name = req.Question[0].Name
+		entry.Type = dns.Type(req.Question[0].Qtype).String()
+	}
+	if reply != nil {
+		entry.Rcode = dns.RcodeToString[reply.Rcode]
+		entry.AnswerCount = len(reply.Answer)
+	}
+	return entry
+}
+
+// JsonQueryLogger writes query log entries as json lines through the logger.
+// Only a fraction of the queries are written according to the sample rate.
+type JsonQueryLogger struct {
+	logger     logging.Logger
+	sampleRate float64
+}
+
+// NewJsonQueryLogger creates the json query logger. sampleRate in (0,1] is the fraction of queries to log.
+func NewJsonQueryLogger(logger logging.Logger, sampleRate float64) *JsonQueryLogger {
+	return &JsonQueryLogger{
+		logger:     logger,
+		sampleRate: sampleRate,
+	}
+}
+
+func (j *JsonQueryLogger) LogQuery(ctx *RequestContext, req, reply *dns.Msg) {
+	if !sampled(j.sampleRate) {
+		return
+	}
+	data, err := json.Marshal(NewQueryLogEntry(ctx, req, reply))
+	if err != nil {
+		logger.Error("marshal query log entry failed:", err)
+		return
+	}
+	j.logger.Info(string(data))
+}
+
+// DnstapQueryLogger writes query log entries as dnstap CLIENT_RESPONSE frames through the writer.
+// The frame carries the query and the response messages, and the json entry in the extra field
+// for the fields not covered by dnstap, e.g. handler path and cache hit.
+// Queries without reply are written as CLIENT_QUERY frames.
+type DnstapQueryLogger struct {
+	writer     *DnstapWriter
+	sampleRate float64
+}
+
+// NewDnstapQueryLogger creates the dnstap query logger. sampleRate in (0,1] is the fraction of queries to log.
+func NewDnstapQueryLogger(writer *DnstapWriter, sampleRate float64) *DnstapQueryLogger {
+	return &DnstapQueryLogger{
+		writer:     writer,
+		sampleRate: sampleRate,
+	}
+}
+
+func (d *DnstapQueryLogger) LogQuery(ctx *RequestContext, req, reply *dns.Msg) {
+	if !sampled(d.sampleRate) {
+		return
+	}
+	if reply == nil {
+		d.writer.ClientQuery(ctx, req)
+		return
+	}
+	extra, err := json.Marshal(NewQueryLogEntry(ctx, req, reply))
+	if err != nil {
+		logger.Error("marshal query log entry failed:", err)
+		return
+	}
+	d.writer.write(d.writer.newClientResponseMessage(ctx, req, reply), extra)
+}
+
+func sampled(sampleRate float64) bool {
+	return sampleRate >= 1 || rand.Float64() < sampleRate
+}
+
+var _ QueryLogger = new(JsonQueryLogger)
+var _ QueryLogger = new(DnstapQueryLogger)
@@ -0,0 +1,88 @@
+package dnscore
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestQueryLogEntry(t *testing.T) {
+	endpoint, err := NewDnsEndpoint("udp://127.0.0.1:0", testDnsStorage{"a.example.dns.": {"10.0.0.1"}}, nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeA)
+	for i, cacheHit := range []bool{false, true} {
+		ctx := NewRequestContext()
+		ctx.SetClientAddrString("192.168.1.10:53000")
+		ctx.Protocol = ProtocolUdp
+		reply := endpoint.ProcessDnsMsg(req, ctx)
+		entry := NewQueryLogEntry(ctx, req, reply)
+		if entry.ClientIP != "192.168.1.10" || entry.Name != "a.example.dns." || entry.Type != "A" ||
+			entry.Rcode != "NOERROR" || entry.AnswerCount != 1 || entry.CacheHit != cacheHit {
+			t.Fatal("unexpected entry:", i, entry)
+		}
+		if !cacheHit && (len(entry.Handlers) != 1 || entry.Handlers[0] != "RecordAHandler") {
+			t.Fatal("unexpected handler path:", entry.Handlers)
+		}
+	}
+
+	req = new(dns.Msg).SetQuestion("b.example.dns.", dns.TypeA)
+	ctx := NewRequestContext()
+	entry := NewQueryLogEntry(ctx, req, endpoint.ProcessDnsMsg(req, ctx))
+	if entry.Rcode != "NXDOMAIN" || len(entry.Handlers) != 2 || entry.Handlers[1] != "NotFoundUpstreamDns" {
+		t.Fatal("unexpected entry:", entry)
+	}
+}
+
+func TestDnstapQueryLogger(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "querylog.dnstap")
+	writer, err := NewDnstapWriter("file", file, "node1", "test", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Start()
+	queryLogger := NewDnstapQueryLogger(writer, 1)
+
+	ctx := NewRequestContext()
+	ctx.SetClientAddrString("192.168.1.10:53000")
+	ctx.EnterHandler("RecordAHandler")
+	req := new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeA)
+	queryLogger.LogQuery(ctx, req, new(dns.Msg).SetReply(req))
+	queryLogger.LogQuery(ctx, req, nil)
+	writer.Close()
+
+	input, err := dnstap.NewFrameStreamInputFromFilename(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan []byte, 8)
+	go func() {
+		input.ReadInto(ch)
+		close(ch)
+	}()
+	var frames []*dnstap.Dnstap
+	for frame := range ch {
+		msg := new(dnstap.Dnstap)
+		if err := proto.Unmarshal(frame, msg); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, msg)
+	}
+	if len(frames) != 2 || frames[0].Message.GetType() != dnstap.Message_CLIENT_RESPONSE ||
+		frames[1].Message.GetType() != dnstap.Message_CLIENT_QUERY {
+		t.Fatal("unexpected frames:", frames)
+	}
+	entry := new(QueryLogEntry)
+	if err := json.Unmarshal(frames[0].Extra, entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name != "a.example.dns." || entry.Rcode != "NOERROR" || len(entry.Handlers) != 1 || entry.Handlers[0] != "RecordAHandler" {
+		t.Fatal("unexpected entry in extra field:", entry)
+	}
+}
@@ -0,0 +1,220 @@
+package dnscore
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	DefaultRateLimitIPv4PrefixLen = 32
+	DefaultRateLimitIPv6PrefixLen = 56
+	DefaultRrlWindow              = 15
+	DefaultRrlSlip                = 2
+
+	rateLimitCleanupInterval = 10 * time.Second
+)
+
+type RateLimitConfig struct {
+	// QueriesPerSecond per client prefix. Zero disables client rate limiting.
+	QueriesPerSecond float64
+	Burst            int
+	// clients are grouped by prefix, e.g. 24 to limit a /24 network as a whole
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+
+	// RrlResponsesPerSecond of identical responses to the same client prefix. Zero disables RRL.
+	RrlResponsesPerSecond float64
+	// RrlWindow in seconds is the burst of identical responses
+	RrlWindow int
+	// RrlSlip: every Nth limited response is sent as a truncated response asking the client to retry over TCP.
+	// 0 drops all the limited responses and 1 truncates all of them.
+	RrlSlip int
+}
+
+type RateLimitStatus struct {
+	Allowed     uint64 `json:"allowed"`
+	Limited     uint64 `json:"limited"`
+	RrlDropped  uint64 `json:"rrl_dropped"`
+	RrlSlipped  uint64 `json:"rrl_slipped"`
+	ClientCount int    `json:"client_count"`
+	RrlKeyCount int    `json:"rrl_key_count"`
+}
+
+// RateLimiter limits queries per client prefix with token buckets
+// and applies response rate limiting to identical responses over UDP
+type RateLimiter struct {
+	ipv4Mask net.IPMask
+	ipv6Mask net.IPMask
+	slip     int
+
+	clients *bucketLimiter
+	rrl     *bucketLimiter
+
+	allowed    atomic.Uint64
+	limited    atomic.Uint64
+	rrlDropped atomic.Uint64
+	rrlSlipped atomic.Uint64
+	rrlCounter atomic.Uint64
+}
+
+func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
+	ipv4PrefixLen := config.IPv4PrefixLen
+	if ipv4PrefixLen <= 0 || ipv4PrefixLen > 32 {
+		ipv4PrefixLen = DefaultRateLimitIPv4PrefixLen
+	}
+	ipv6PrefixLen := config.IPv6PrefixLen
+	if ipv6PrefixLen <= 0 || ipv6PrefixLen > 128 {
+		ipv6PrefixLen = DefaultRateLimitIPv6PrefixLen
+	}
+	r := &RateLimiter{
+		ipv4Mask: net.CIDRMask(ipv4PrefixLen, 32),
+		ipv6Mask: net.CIDRMask(ipv6PrefixLen, 128),
+		slip:     config.RrlSlip,
+	}
+	if config.QueriesPerSecond > 0 {
+		burst := float64(config.Burst)
+		if burst < config.QueriesPerSecond {
+			burst = config.QueriesPerSecond
+		}
+		r.clients = newBucketLimiter(config.QueriesPerSecond, burst)
+	}
+	if config.RrlResponsesPerSecond > 0 {
+		window := config.RrlWindow
+		if window <= 0 {
+			window = DefaultRrlWindow
+		}
+		r.rrl = newBucketLimiter(config.RrlResponsesPerSecond, config.RrlResponsesPerSecond*float64(window))
+	}
+	return r
+}
+
+// AllowQuery checks the query rate of the client prefix
+func (r *RateLimiter) AllowQuery(ctx *RequestContext) bool {
+	if r.clients == nil {
+		return true
+	}
+	if r.clients.allow(r.clientKey(ctx.ClientIP), time.Now()) {
+		r.allowed.Add(1)
+		return true
+	}
+	r.limited.Add(1)
+	ctx.AddTraceInfo("RateLimit-Limited")
+	return false
+}
+
+// LimitResponse applies RRL to the reply. It returns the reply, a truncated reply, or nil to drop the response.
+// Only UDP responses are limited since TCP clients cannot spoof the source address.
+func (r *RateLimiter) LimitResponse(ctx *RequestContext, req, reply *dns.Msg) *dns.Msg {
+	if r.rrl == nil || reply == nil || ctx.Protocol != ProtocolUdp || len(req.Question) == 0 {
+		return reply
+	}
+	question := req.Question[0]
+	key := fmt.Sprint(r.clientKey(ctx.ClientIP), "|", question.Name, "|", question.Qtype, "|", reply.Rcode)
+	if r.rrl.allow(key, time.Now()) {
+		return reply
+	}
+	if r.slip > 0 && r.rrlCounter.Add(1)%uint64(r.slip) == 0 {
+		r.rrlSlipped.Add(1)
+		ctx.AddTraceInfo("RRL-Slipped")
+		truncated := new(dns.Msg).SetReply(req)
+		truncated.Truncated = true
+		return truncated
+	}
+	r.rrlDropped.Add(1)
+	ctx.AddTraceInfo("RRL-Dropped")
+	return nil
+}
+
+func (r *RateLimiter) Status() RateLimitStatus {
+	status := RateLimitStatus{
+		Allowed:    r.allowed.Load(),
+		Limited:    r.limited.Load(),
+		RrlDropped: r.rrlDropped.Load(),
+		RrlSlipped: r.rrlSlipped.Load(),
+	}
+	if r.clients != nil {
+		status.ClientCount = r.clients.size()
+	}
+	if r.rrl != nil {
+		status.RrlKeyCount = r.rrl.size()
+	}
+	return status
+}
+
+func (r *RateLimiter) clientKey(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return clientIP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(r.ipv4Mask).String()
+	}
+	return ip.Mask(r.ipv6Mask).String()
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketLimiter holds token buckets by key. Idle buckets are removed once they are refilled.
+type bucketLimiter struct {
+	rate  float64
+	burst float64
+
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	lock        sync.Mutex
+}
+
+func newBucketLimiter(rate, burst float64) *bucketLimiter {
+	return &bucketLimiter{
+		rate:        rate,
+		burst:       burst,
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+func (b *bucketLimiter) allow(key string, now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if now.Sub(b.lastCleanup) > rateLimitCleanupInterval {
+		b.cleanup(now)
+	}
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: b.burst, last: now}
+		b.buckets[key] = bucket
+	} else {
+		bucket.tokens = min(b.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*b.rate)
+		bucket.last = now
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (b *bucketLimiter) cleanup(now time.Time) {
+	b.lastCleanup = now
+	for key, bucket := range b.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*b.rate >= b.burst {
+			delete(b.buckets, key)
+		}
+	}
+}
+
+func (b *bucketLimiter) size() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.buckets)
+}
@@ -0,0 +1,82 @@
+package dnscore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestBucketLimiter(t *testing.T) {
+	b := newBucketLimiter(2, 2)
+	now := time.Now()
+	if !b.allow("k", now) || !b.allow("k", now) {
+		t.Fatal("burst should be allowed")
+	}
+	if b.allow("k", now) {
+		t.Fatal("exceeded burst should be limited")
+	}
+	if !b.allow("other", now) {
+		t.Fatal("other key should be allowed")
+	}
+	if !b.allow("k", now.Add(500*time.Millisecond)) {
+		t.Fatal("refilled token should be allowed")
+	}
+	b.cleanup(now.Add(time.Minute))
+	if b.size() != 0 {
+		t.Fatal("idle buckets should be removed")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(&RateLimitConfig{
+		QueriesPerSecond:      1,
+		Burst:                 2,
+		IPv4PrefixLen:         24,
+		RrlResponsesPerSecond: 1,
+		RrlWindow:             1,
+		RrlSlip:               2,
+	})
+
+	ctx := func(ip string) *RequestContext {
+		c := NewRequestContext()
+		c.ClientIP = ip
+		c.Protocol = ProtocolUdp
+		return c
+	}
+	// clients in the same /24 share the bucket
+	if !limiter.AllowQuery(ctx("10.0.0.1")) || !limiter.AllowQuery(ctx("10.0.0.2")) || limiter.AllowQuery(ctx("10.0.0.3")) {
+		t.Fatal("unexpected client rate limiting")
+	}
+	if !limiter.AllowQuery(ctx("10.0.1.1")) {
+		t.Fatal("other prefix should be allowed")
+	}
+
+	req := new(dns.Msg).SetQuestion("a.example.dns.", dns.TypeA)
+	reply := new(dns.Msg).SetReply(req)
+	if r := limiter.LimitResponse(ctx("10.0.0.1"), req, reply); r != reply {
+		t.Fatal("first response should be sent")
+	}
+	var dropped, truncated int
+	for i := 0; i < 4; i++ {
+		r := limiter.LimitResponse(ctx("10.0.0.1"), req, reply)
+		if r == nil {
+			dropped++
+		} else if r.Truncated {
+			truncated++
+		}
+	}
+	if dropped != 2 || truncated != 2 {
+		t.Fatal("unexpected rrl results:", dropped, truncated)
+	}
+	tcpCtx := ctx("10.0.0.1")
+	tcpCtx.Protocol = ProtocolTcp
+	if r := limiter.LimitResponse(tcpCtx, req, reply); r != reply {
+		t.Fatal("tcp responses should not be limited")
+	}
+
+	status := limiter.Status()
+	if status.Allowed != 3 || status.Limited != 1 || status.RrlDropped != 2 || status.RrlSlipped != 2 {
+		t.Fatal("unexpected status:", status)
+	}
+}
@@ -52,6 +52,9 @@ func (this *DnsHttp) StartSync() error {
 
 func (this *DnsHttp) dnsQuery(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	reqCtx := NewRequestContext()
+	reqCtx.SetClientAddrString(r.RemoteAddr)
+	reqCtx.Protocol = ProtocolDoh
+	var msg, reply *dns.Msg
 
 	defer func() {
 		err := recover()
@@ -62,6 +65,7 @@ func (this *DnsHttp) dnsQuery(w http.ResponseWriter, r *http.Request, _ httprout
 		if this.DebugPrintDnsRequest {
 			logger.Debug("Domain resolve info:", reqCtx.GetTraceInfoString())
 		}
+		this.endpoint.finishRequest(reqCtx, msg, reply)
 	}()
 
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -135,15 +139,20 @@ func (this *DnsHttp) dnsQuery(w http.ResponseWriter, r *http.Request, _ httprout
 		return
 	}
 
-	msg := new(dns.Msg)
+	msg = new(dns.Msg)
 	err := msg.Unpack(reqBin)
 	if err != nil {
+		msg = nil
 		logger.Error("dns unpack err:", err)
 		w.WriteHeader(400)
 		return
 	}
 
-	reply := this.endpoint.ProcessDnsMsg(msg, reqCtx)
+	if limiter := this.endpoint.RateLimiter; limiter != nil && !limiter.AllowQuery(reqCtx) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	reply = this.endpoint.ProcessDnsMsg(msg, reqCtx)
 
 	w.Header().Set("Content-Type", "application/dns-message")
 	now := time.Now().UTC().Format(http.TimeFormat)
@@ -12,10 +12,24 @@ type DnsEndpoint struct {
 	Storage DnsStorage
 	Server  *dns.Server
 	Cache   DnsCache
+	// Upstream is nil when no upstream server is configured
+	Upstream *UpstreamDns
 
 	Addr string
 
 	DebugPrintDnsRequest bool
+	// QueryLogger is nil when query log is disabled
+	QueryLogger QueryLogger
+	// Dnstap is nil when dnstap is disabled
+	Dnstap *DnstapWriter
+	// Policy is checked before cache and handlers. It is nil when no policy is configured.
+	Policy DnsPolicy
+	// RateLimiter is nil when rate limiting is disabled
+	RateLimiter *RateLimiter
+	// ACL is nil when all the clients are allowed
+	ACL *AccessControl
+	// Ecs is nil when the client subnet option is passed through
+	Ecs *EcsConfig
 
 	HandlerMapping map[uint16]DnsRecordHandler
 }
@@ -44,7 +58,8 @@ func NewDnsEndpoint(addr string, storage DnsStorage, upstreams []string, enclosu
 	{
 		var parentHandler DnsRecordHandler
 		if len(upstreams) > 0 {
-			parentHandler = NewUpstreamDNSWithSingle(upstreams, enclosureDomainSuffixes)
+			endpoint.Upstream = NewUpstreamDNSWithSingle(upstreams, enclosureDomainSuffixes)
+			parentHandler = endpoint.Upstream
 		}
 		endpoint.HandlerMapping[dns.TypeA] = NewRecordAHandler(parentHandler, storage, endpoint.DebugPrintDnsRequest)
 		endpoint.HandlerMapping[dns.TypeTXT] = NewRecordTxtHandler(parentHandler, storage, endpoint.DebugPrintDnsRequest)
@@ -56,12 +71,29 @@ func NewDnsEndpoint(addr string, storage DnsStorage, upstreams []string, enclosu
 	return endpoint, nil
 }
 
+// ReloadUpstream replaces upstream servers and enclosure domains and purges cached responses.
+// Upstream can only be reloaded when it is configured at startup.
+func (d *DnsEndpoint) ReloadUpstream(upstreams []string, enclosureDomainSuffixes []struct {
+	Type   string
+	Suffix string
+}) error {
+	if d.Upstream == nil || len(upstreams) == 0 {
+		return errors.New("enabling or disabling upstream requires restart")
+	}
+	d.Upstream.Reload(upstreams, enclosureDomainSuffixes)
+	d.Cache.Purge()
+	return nil
+}
+
 func (d *DnsEndpoint) StartSync() error {
 	return d.Server.ListenAndServe()
 }
 
 func (d *DnsEndpoint) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	reqCtx := NewRequestContext()
+	reqCtx.SetClientAddr(w.RemoteAddr())
+	reqCtx.Protocol = w.LocalAddr().Network()
+	var reply *dns.Msg
 
 	defer func() {
 		err := recover()
@@ -72,9 +104,16 @@ func (d *DnsEndpoint) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		if d.DebugPrintDnsRequest {
 			logger.Debug("Domain resolve info:", reqCtx.GetTraceInfoString())
 		}
+		d.finishRequest(reqCtx, r, reply)
 	}()
 
-	reply := d.ProcessDnsMsg(r, reqCtx)
+	if d.RateLimiter != nil && !d.RateLimiter.AllowQuery(reqCtx) {
+		return
+	}
+	reply = d.ProcessDnsMsg(r, reqCtx)
+	if d.RateLimiter != nil {
+		reply = d.RateLimiter.LimitResponse(reqCtx, r, reply)
+	}
 	if reply == nil {
 		return
 	}
@@ -83,7 +122,52 @@ func (d *DnsEndpoint) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// EnableDns64 synthesizes AAAA records from A records of both local records and upstream
+func (d *DnsEndpoint) EnableDns64(config *Dns64Config) {
+	if handler, ok := d.HandlerMapping[dns.TypeAAAA].(*RecordAAAAHandler); ok {
+		handler.Dns64 = config
+	}
+}
+
+// EnableHealthCheck omits unhealthy targets from local A, AAAA and SRV answers
+func (d *DnsEndpoint) EnableHealthCheck(checker *HealthChecker) {
+	for _, handler := range d.HandlerMapping {
+		switch h := handler.(type) {
+		case *RecordAHandler:
+			h.Health = checker
+		case *RecordAAAAHandler:
+			h.Health = checker
+		case *RecordSRVHandler:
+			h.Health = checker
+		}
+	}
+}
+
+// EnableDnstap emits dnstap frames of client and forwarder messages to the writer
+func (d *DnsEndpoint) EnableDnstap(writer *DnstapWriter) {
+	d.Dnstap = writer
+	if d.Upstream != nil {
+		d.Upstream.Dnstap = writer
+	}
+}
+
+// finishRequest writes the query log and the dnstap response if enabled. reply is nil when no response is sent.
+func (d *DnsEndpoint) finishRequest(ctx *RequestContext, req, reply *dns.Msg) {
+	if req == nil {
+		return
+	}
+	if d.Dnstap != nil && reply != nil {
+		d.Dnstap.ClientResponse(ctx, req, reply)
+	}
+	if d.QueryLogger != nil {
+		d.QueryLogger.LogQuery(ctx, req, reply)
+	}
+}
+
 func (d *DnsEndpoint) ProcessDnsMsg(r *dns.Msg, ctx *RequestContext) *dns.Msg {
+	if d.Dnstap != nil {
+		d.Dnstap.ClientQuery(ctx, r)
+	}
 	if r.Opcode == dns.OpcodeQuery && len(r.Question) > 1 {
 		// treat question count > 1 as incorrectly-formatted message according to rfc9619
 		reply := new(dns.Msg)
@@ -91,13 +175,55 @@ func (d *DnsEndpoint) ProcessDnsMsg(r *dns.Msg, ctx *RequestContext) *dns.Msg {
 	}
 
 	ctx.AddTraceInfo(fmt.Sprint("resolve:t=", r.Question[0].Qtype, ",domain:", r.Question[0].Name))
+	clientSubnet := findClientSubnet(r)
+	ctx.ClientSubnet = formatClientSubnet(clientSubnet)
+	if d.Ecs != nil {
+		r = d.Ecs.prepareRequest(r, ctx)
+	}
+	return finalizeClientSubnet(d.resolve(r, ctx), clientSubnet)
+}
+
+// resolve processes the query with the client subnet option to send to upstream.
+// Cached entries are scoped by the client subnet of the query.
+func (d *DnsEndpoint) resolve(r *dns.Msg, ctx *RequestContext) *dns.Msg {
+	if d.ACL != nil {
+		d.ACL.Check(ctx)
+		if ctx.ManagedZonesDenied && ctx.RecursionDenied {
+			return newRefusedReply(r, ctx, "client")
+		}
+	}
+	// responses to restricted clients are neither served from nor stored into cache
+	// since cached responses may come from either local records or upstream
+	useCache := !ctx.ManagedZonesDenied && !ctx.RecursionDenied
+	// check policies before the results from cache or upstream are used
+	if d.Policy != nil {
+		res, err := d.Policy.ApplyPolicy(r, ctx)
+		if errors.Is(err, ErrDoNotRespondResult) {
+			return nil
+		} else if err != nil {
+			panic(errors.New("apply dns policy failed. " + err.Error()))
+		}
+		if res != nil {
+			return res
+		}
+	}
 	// query cache
-	if res := d.Cache.Get(r); res != nil {
-		ctx.AddTraceInfoWithDnsAnswersIfNoError("hit_mem_cache", res, nil)
-		return res
+	if useCache {
+		if res := d.Cache.Get(r); res != nil {
+			ctx.CacheHit = true
+			ctx.AddTraceInfoWithDnsAnswersIfNoError("hit_mem_cache", res, nil)
+			return res
+		}
 	}
 	// query pipeline
 	handler, ok := d.HandlerMapping[r.Question[0].Qtype]
+	if ctx.ManagedZonesDenied {
+		// skip local records
+		if d.Upstream == nil {
+			return newRefusedReply(r, ctx, "managed_zones")
+		}
+		handler, ok = d.Upstream, true
+	}
 	if !ok {
 		ctx.AddTraceInfo("unknown request type:" + fmt.Sprint(r.Question[0].Qtype))
 		result, err := NotFoundUpstreamDns{}.HandleQuestion(r, ctx)
@@ -115,6 +241,8 @@ func (d *DnsEndpoint) ProcessDnsMsg(r *dns.Msg, ctx *RequestContext) *dns.Msg {
 		panic(errors.New("dns request failed. " + err.Error()))
 	}
 	// cache result
-	d.Cache.Put(r, res)
+	if useCache {
+		d.Cache.Put(r, res)
+	}
 	return res
 }
@@ -2,27 +2,87 @@ package dnscore
 
 import (
 	"context"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
 
+const (
+	ProtocolUdp = "udp"
+	ProtocolTcp = "tcp"
+	ProtocolDoh = "doh"
+)
+
 type RequestContext struct {
 	Ctx context.Context
 
-	traceInfos []string
+	// ClientIP is the address of the client sending the request
+	ClientIP   string
+	ClientPort int
+	// Protocol is the transport of the request: udp/tcp/doh
+	Protocol  string
+	StartTime time.Time
+	CacheHit  bool
+	// ClientSubnet is the EDNS client subnet option sent by the client
+	ClientSubnet string
+
+	// set by access control
+	ManagedZonesDenied bool
+	RecursionDenied    bool
+
+	traceInfos   []string
+	handlerPaths []string
 }
 
 func NewRequestContext() *RequestContext {
 	return &RequestContext{
+		StartTime:  time.Now(),
 		traceInfos: make([]string, 0, 4),
 	}
 }
 
+// SetClientAddr extracts client ip from the remote address
+func (r *RequestContext) SetClientAddr(addr net.Addr) {
+	switch v := addr.(type) {
+	case *net.UDPAddr:
+		r.ClientIP = v.IP.String()
+		r.ClientPort = v.Port
+	case *net.TCPAddr:
+		r.ClientIP = v.IP.String()
+		r.ClientPort = v.Port
+	case nil:
+	default:
+		r.SetClientAddrString(v.String())
+	}
+}
+
+// SetClientAddrString extracts client ip from the remote address in host:port format
+func (r *RequestContext) SetClientAddrString(addr string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		r.ClientIP = host
+		r.ClientPort, _ = strconv.Atoi(port)
+	} else {
+		r.ClientIP = addr
+	}
+}
+
 func (r *RequestContext) AddTraceInfo(info string) {
 	r.traceInfos = append(r.traceInfos, info)
 }
 
+// EnterHandler records the handler processing the request in both trace info and handler path
+func (r *RequestContext) EnterHandler(handler string) {
+	r.handlerPaths = append(r.handlerPaths, handler)
+	r.AddTraceInfo(handler)
+}
+
+func (r *RequestContext) HandlerPaths() []string {
+	return r.handlerPaths
+}
+
 func (r *RequestContext) AddTraceInfoWithDnsAnswersIfNoError(info string, msg *dns.Msg, err error) {
 	if err != nil {
 		return
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -13,7 +15,7 @@ type NotFoundUpstreamDns struct {
 }
 
 func (u NotFoundUpstreamDns) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns.Msg, error) {
-	ctx.AddTraceInfo("NotFoundUpstreamDns")
+	ctx.EnterHandler("NotFoundUpstreamDns")
 	reply := new(dns.Msg)
 	//FIXME Not good to directly respond NXDomain due to the reason in the below.
 	// Should check if there is any other types to the same domain name and determine to respond NXDomain or NOERROR.
@@ -36,6 +38,13 @@ func (u NotFoundUpstreamDns) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*d
 }
 
 type UpstreamDns struct {
+	config *atomic.Value
+
+	// Dnstap is nil when dnstap is disabled
+	Dnstap *DnstapWriter
+}
+
+type upstreamDnsConfig struct {
 	cfg *dns.ClientConfig
 
 	enclosureSuffixMap map[string][]string
@@ -46,6 +55,18 @@ func NewUpstreamDNSWithSingle(server []string, suffixes []struct {
 	Type   string
 	Suffix string
 }) *UpstreamDns {
+	u := &UpstreamDns{
+		config: new(atomic.Value),
+	}
+	u.Reload(server, suffixes)
+	return u
+}
+
+// Reload replaces upstream servers and enclosure domains. In-flight queries keep using the previous ones.
+func (u *UpstreamDns) Reload(server []string, suffixes []struct {
+	Type   string
+	Suffix string
+}) {
 	enclosureSuffixMap := make(map[string][]string)
 	for _, v := range suffixes {
 		suffix := v.Suffix
@@ -70,27 +91,43 @@ func NewUpstreamDNSWithSingle(server []string, suffixes []struct {
 		Timeout:  5,
 		Attempts: 2,
 	}
-	return &UpstreamDns{
+	u.config.Store(&upstreamDnsConfig{
 		cfg: &cfg,
 
 		enclosureSuffixMap: enclosureSuffixMap,
 		enclosureDomainMap: enclosureDomainMap,
-	}
+	})
+}
+
+func (u *UpstreamDns) getConfig() *upstreamDnsConfig {
+	return u.config.Load().(*upstreamDnsConfig)
 }
 
 func (u *UpstreamDns) HandleQuestion(m *dns.Msg, ctx *RequestContext) (*dns.Msg, error) {
-	res := u.handleEnclosureDomains(ctx, strings.ToLower(m.Question[0].Name), m.Question[0].Qtype, m)
+	config := u.getConfig()
+	res := config.handleEnclosureDomains(ctx, strings.ToLower(m.Question[0].Name), m.Question[0].Qtype, m)
 	if res != nil {
 		return res, nil
 	}
 
-	ctx.AddTraceInfo("UpstreamDns")
-	r, err := dns.Exchange(m, net.JoinHostPort(u.cfg.Servers[0], u.cfg.Port))
+	ctx.EnterHandler("UpstreamDns")
+	if ctx.RecursionDenied {
+		return newRefusedReply(m, ctx, "recursion"), nil
+	}
+	server := net.JoinHostPort(config.cfg.Servers[0], config.cfg.Port)
+	queryTime := time.Now()
+	if u.Dnstap != nil {
+		u.Dnstap.ForwarderQuery(server, queryTime, m)
+	}
+	r, err := dns.Exchange(m, server)
+	if u.Dnstap != nil && err == nil {
+		u.Dnstap.ForwarderResponse(server, queryTime, m, r)
+	}
 	ctx.AddTraceInfoWithDnsAnswersIfNoError("UpstreamDns->", r, err)
 	return r, err
 }
 
-func (u *UpstreamDns) handleEnclosureDomains(ctx *RequestContext, domain string, qtype uint16, raw *dns.Msg) *dns.Msg {
+func (u *upstreamDnsConfig) handleEnclosureDomains(ctx *RequestContext, domain string, qtype uint16, raw *dns.Msg) *dns.Msg {
 	var key string
 	switch qtype {
 	case dns.TypeA:
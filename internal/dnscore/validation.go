@@ -0,0 +1,70 @@
+package dnscore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const maxTxtStringLength = 255
+
+// ValidateDomainName checks the domain against the syntax and length limits of rfc1035
+func ValidateDomainName(domain string) error {
+	if strings.TrimSpace(domain) == "" {
+		return errors.New("empty domain name")
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return fmt.Errorf("invalid domain name:%s", domain)
+	}
+	return nil
+}
+
+// ValidateRecord checks the domain name and the stored value of the record.
+// For PTR records, the domain is the reverse lookup name.
+func ValidateRecord(domainType shared.DomainType, domain, value string) error {
+	if err := ValidateDomainName(domain); err != nil {
+		return err
+	}
+	switch domainType {
+	case shared.DomainTypeA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address of A record:%s->%s", domain, value)
+		}
+	case shared.DomainTypeAAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address of AAAA record:%s->%s", domain, value)
+		}
+	case shared.DomainTypeTxt:
+		if len(value) > maxTxtStringLength {
+			return fmt.Errorf("TXT record exceeds %d characters:%s", maxTxtStringLength, domain)
+		}
+	case shared.DomainTypeSrv:
+		srv := new(SrvRecordValue)
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(srv); err != nil {
+			return fmt.Errorf("invalid SRV record:%s->%s, error:%w", domain, value, err)
+		}
+		if err := ValidateDomainName(srv.Target); err != nil {
+			return fmt.Errorf("invalid SRV record target:%s->%s, error:%w", domain, value, err)
+		}
+	case shared.DomainTypePtr:
+		if value == "." {
+			return fmt.Errorf("invalid PTR record pointing to root:%s", domain)
+		}
+		if err := ValidateDomainName(value); err != nil {
+			return fmt.Errorf("invalid PTR record:%s->%s, error:%w", domain, value, err)
+		}
+	default:
+		return fmt.Errorf("unsupported record type:%d", domainType)
+	}
+	return nil
+}
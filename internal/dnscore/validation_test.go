@@ -0,0 +1,48 @@
+package dnscore
+
+import (
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func TestValidateRecord(t *testing.T) {
+	valid := []struct {
+		t      shared.DomainType
+		domain string
+		value  string
+	}{
+		{shared.DomainTypeA, "node1.example.dns.", "127.0.0.1"},
+		{shared.DomainTypeAAAA, "node1.example.dns.", "::1"},
+		{shared.DomainTypeTxt, "node1.example.dns.", "Hello World"},
+		{shared.DomainTypeSrv, "_svc._tcp.example.dns.", `{"priority":10,"weight":20,"port":30,"target":"service.node1.example.dns"}`},
+		{shared.DomainTypePtr, "8.8.8.8.in-addr.arpa.", "demo1.example.com."},
+	}
+	for _, v := range valid {
+		if err := ValidateRecord(v.t, v.domain, v.value); err != nil {
+			t.Fatal("record should be valid:", v, err)
+		}
+	}
+
+	invalid := []struct {
+		t      shared.DomainType
+		domain string
+		value  string
+	}{
+		{shared.DomainTypeA, "node1.example.dns.", "999.0.0.1"},
+		{shared.DomainTypeA, "node1.example.dns.", "::1"},
+		{shared.DomainTypeAAAA, "node1.example.dns.", "127.0.0.1"},
+		{shared.DomainTypeA, "", "127.0.0.1"},
+		{shared.DomainTypeA, "node1..example.dns.", "127.0.0.1"},
+		{shared.DomainTypeSrv, "_svc._tcp.example.dns.", `{"priority":"10"}`},
+		{shared.DomainTypeSrv, "_svc._tcp.example.dns.", `{"priority":10,"prio":20,"target":"a.b"}`},
+		{shared.DomainTypeSrv, "_svc._tcp.example.dns.", `{"priority":10,"weight":20,"port":30,"target":""}`},
+		{shared.DomainTypePtr, "8.8.8.8.in-addr.arpa.", ""},
+		{shared.DomainTypePtr, "8.8.8.8.in-addr.arpa.", "."},
+	}
+	for _, v := range invalid {
+		if err := ValidateRecord(v.t, v.domain, v.value); err == nil {
+			t.Fatal("record should be invalid:", v)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dnsdyn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const (
+	FormatToml = "toml"
+	FormatJson = "json"
+	FormatYaml = "yaml"
+)
+
+type ConfigureContainer struct {
+	A    map[string]string `toml:"A" json:"A" yaml:"A"`
+	TXT  map[string]string `toml:"TXT" json:"TXT" yaml:"TXT"`
+	SRV  map[string]string `toml:"SRV" json:"SRV" yaml:"SRV"`
+	PTR  map[string]string `toml:"PTR" json:"PTR" yaml:"PTR"`
+	AAAA map[string]string `toml:"AAAA" json:"AAAA" yaml:"AAAA"`
+}
+
+// DecodeConfigureContainer decodes records in the given format: toml, json or yaml
+func DecodeConfigureContainer(format string, data []byte) (*ConfigureContainer, error) {
+	container := new(ConfigureContainer)
+	var err error
+	switch format {
+	case FormatToml:
+		err = toml.Unmarshal(data, container)
+	case FormatJson:
+		err = json.Unmarshal(data, container)
+	case FormatYaml:
+		err = yaml.Unmarshal(data, container)
+	default:
+		return nil, errors.New("unsupported record format:" + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// FormatFromFileName detects the record format from the file extension
+func FormatFromFileName(name string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml":
+		return FormatToml, true
+	case ".json":
+		return FormatJson, true
+	case ".yaml", ".yml":
+		return FormatYaml, true
+	default:
+		return "", false
+	}
+}
+
+type ResolveContainer struct {
+	A    map[string]string
+	TXT  map[string]string
+	SRV  map[string]string
+	PTR  map[string]string
+	AAAA map[string]string
+}
+
+func NewResolveContainer() *ResolveContainer {
+	return &ResolveContainer{
+		A:    map[string]string{},
+		TXT:  map[string]string{},
+		SRV:  map[string]string{},
+		PTR:  map[string]string{},
+		AAAA: map[string]string{},
+	}
+}
+
+func (rc *ResolveContainer) typeMapping(domainType shared.DomainType) map[string]string {
+	switch domainType {
+	case shared.DomainTypeA:
+		return rc.A
+	case shared.DomainTypeTxt:
+		return rc.TXT
+	case shared.DomainTypeSrv:
+		return rc.SRV
+	case shared.DomainTypePtr:
+		return rc.PTR
+	case shared.DomainTypeAAAA:
+		return rc.AAAA
+	default:
+		return nil
+	}
+}
+
+// Resolve looks up the normalized domain
+func (rc *ResolveContainer) Resolve(domain string, domainType shared.DomainType) (string, bool) {
+	val, ok := rc.typeMapping(domainType)[domain]
+	return val, ok
+}
+
+// Merge adds records from src which don't exist in rc.
+// Records with the same name and type but different values are kept unchanged and returned as conflicts.
+func (rc *ResolveContainer) Merge(src *ResolveContainer) (conflicts []error) {
+	mergeFn := func(typeName string, dst, src map[string]string) {
+		for k, v := range src {
+			if exist, ok := dst[k]; !ok {
+				dst[k] = v
+			} else if exist != v {
+				conflicts = append(conflicts, fmt.Errorf("conflict %s records:%s->[%s] and [%s]", typeName, k, exist, v))
+			}
+		}
+	}
+	mergeFn("A", rc.A, src.A)
+	mergeFn("TXT", rc.TXT, src.TXT)
+	mergeFn("SRV", rc.SRV, src.SRV)
+	mergeFn("PTR", rc.PTR, src.PTR)
+	mergeFn("AAAA", rc.AAAA, src.AAAA)
+	return
+}
@@ -3,48 +3,56 @@ package dnsdyn
 import (
 	"context"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/meidoworks/nekoq-component/configure/configapi"
 	"github.com/meidoworks/nekoq-component/configure/configclient"
-	"github.com/miekg/dns"
 
-	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
 	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
 )
 
-type ConfigureContainer struct {
-	A    map[string]string `toml:"A"`
-	TXT  map[string]string `toml:"TXT"`
-	SRV  map[string]string `toml:"SRV"`
-	PTR  map[string]string `toml:"PTR"`
-	AAAA map[string]string `toml:"AAAA"`
+const (
+	DefaultSelectors = "app=nekoq-bootstrap,dc=default,env=PROD"
+	DefaultGroup     = "nekoq-bootstrap.dns"
+	DefaultKey       = "records"
+)
+
+type DnsDynConfStoreConfig struct {
+	Servers []string
+	// Selectors in the format of "k1=v1,k2=v2"
+	Selectors string
+	Group     string
+	// Keys are merged in order. Records in the former keys take precedence over the latter ones.
+	Keys []string
 }
 
-type ResolveContainer struct {
-	A    map[string]string
-	TXT  map[string]string
-	SRV  map[string]string
-	PTR  map[string]string
-	AAAA map[string]string
+type DnsDynKeyStatus struct {
+	Key             string    `json:"key"`
+	LastSuccessTime time.Time `json:"last_success_time"`
+	LastFailureTime time.Time `json:"last_failure_time"`
+	LastError       string    `json:"last_error,omitempty"`
+	RejectedCount   int       `json:"rejected_count"`
 }
 
-func NewResolveContainer() *ResolveContainer {
-	return &ResolveContainer{
-		A:    map[string]string{},
-		TXT:  map[string]string{},
-		SRV:  map[string]string{},
-		PTR:  map[string]string{},
-		AAAA: map[string]string{},
-	}
+type DnsDynStatus struct {
+	Selectors string            `json:"selectors"`
+	Group     string            `json:"group"`
+	Keys      []DnsDynKeyStatus `json:"keys"`
 }
 
 type DnsDynConfStore struct {
-	serverLists []string
+	config *DnsDynConfStoreConfig
 
 	client *configclient.Client
-	adv    *configclient.ClientAdv[*ConfigureContainer]
+	advs   []*configclient.ClientAdv[*ConfigureContainer]
+
+	// last good records of each key
+	keyContainers map[string]*ResolveContainer
+	keyStatuses   map[string]*DnsDynKeyStatus
+	keyLock       sync.Mutex
 
 	container *atomic.Value
 }
@@ -53,37 +61,50 @@ func (d *DnsDynConfStore) GetContainer() *ResolveContainer {
 	return d.container.Load().(*ResolveContainer)
 }
 
-func NewDnsDynConfStore(serverList []string) *DnsDynConfStore {
+func NewDnsDynConfStore(config *DnsDynConfStoreConfig) *DnsDynConfStore {
+	if config.Selectors == "" {
+		config.Selectors = DefaultSelectors
+	}
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+	if len(config.Keys) == 0 {
+		config.Keys = []string{DefaultKey}
+	}
 	rcVal := new(atomic.Value)
 	rcVal.Store(NewResolveContainer())
 	return &DnsDynConfStore{
-		serverLists: serverList,
-		container:   rcVal,
+		config:        config,
+		keyContainers: make(map[string]*ResolveContainer),
+		keyStatuses:   make(map[string]*DnsDynKeyStatus),
+		container:     rcVal,
 	}
 }
 
 func (d *DnsDynConfStore) Startup() error {
 	sel := new(configapi.Selectors)
-	if err := sel.Fill("app=nekoq-bootstrap,dc=default,env=PROD"); err != nil {
+	if err := sel.Fill(d.config.Selectors); err != nil {
 		return err
 	}
-	client := configclient.NewClient(d.serverLists, configclient.ClientOptions{
+	client := configclient.NewClient(d.config.Servers, configclient.ClientOptions{
 		OverrideSelectors: sel,
 	})
-	adv := configclient.NewClientAdv[*ConfigureContainer](client)
-	adv.OnChange = d.onChange
-	_, err := adv.Register("nekoq-bootstrap.dns", "records", toml.Unmarshal)
-	if err != nil {
-		defer func(client *configclient.Client) {
-			err := client.StopClient()
-			if err != nil {
-				logger.Error("stop client failed:", err)
-			}
-		}(client)
-		return err
+	for _, key := range d.config.Keys {
+		adv := configclient.NewClientAdv[*ConfigureContainer](client)
+		adv.OnChange = d.onChangeFunc(key)
+		_, err := adv.Register(d.config.Group, key, toml.Unmarshal)
+		if err != nil {
+			defer func(client *configclient.Client) {
+				err := client.StopClient()
+				if err != nil {
+					logger.Error("stop client failed:", err)
+				}
+			}(client)
+			return err
+		}
+		d.advs = append(d.advs, adv)
 	}
 	d.client = client
-	d.adv = adv
 	if err := client.StartClient(); err != nil {
 		defer func(client *configclient.Client) {
 			err := client.StopClient()
@@ -104,46 +125,23 @@ func (d *DnsDynConfStore) Stop() error {
 	return d.client.StopClient()
 }
 
-func (d *DnsDynConfStore) onChange(cfg configapi.Configuration, container *ConfigureContainer) {
-	logger.Info("receive dns record change.")
-	if err := d.process(container); err != nil {
-		logger.Error("process dns record change failed:", err)
-	} else {
-		logger.Info("process dns record change success.")
+func (d *DnsDynConfStore) onChangeFunc(key string) func(cfg configapi.Configuration, container *ConfigureContainer) {
+	return func(cfg configapi.Configuration, container *ConfigureContainer) {
+		logger.Info("receive dns record change. group:", d.config.Group, "key:", key)
+		if err := d.process(key, container); err != nil {
+			logger.Error("reject dns record change and keep the last good records. key:", key, "error:", err)
+		} else {
+			logger.Info("process dns record change success. key:", key)
+		}
 	}
 }
 
 func (d *DnsDynConfStore) ResolveDomain(domain string, domainType shared.DomainType) (string, error) {
 	domain = strings.ToLower(domain)
 
-	switch domainType {
-	case shared.DomainTypeA:
-		val, ok := d.GetContainer().A[domain]
-		if ok {
-			return val, nil
-		}
-	case shared.DomainTypeTxt:
-		val, ok := d.GetContainer().TXT[domain]
-		if ok {
-			return val, nil
-		}
-	case shared.DomainTypeSrv:
-		val, ok := d.GetContainer().SRV[domain]
-		if ok {
-			return val, nil
-		}
-	case shared.DomainTypePtr:
-		val, ok := d.GetContainer().PTR[domain]
-		if ok {
-			return val, nil
-		}
-	case shared.DomainTypeAAAA:
-		val, ok := d.GetContainer().AAAA[domain]
-		if ok {
-			return val, nil
-		}
+	if val, ok := d.GetContainer().Resolve(domain, domainType); ok {
+		return val, nil
 	}
-
 	return "", shared.ErrStorageNotFound
 }
 
@@ -151,25 +149,63 @@ func (d *DnsDynConfStore) PutDomain(domain, resolve string, domainType shared.Do
 	panic("unsupported")
 }
 
-func (d *DnsDynConfStore) process(container *ConfigureContainer) error {
-	rc := NewResolveContainer()
-	for key, val := range container.A {
-		rc.A[dns.Fqdn(strings.ToLower(key))] = val
+// process replaces the records of the key.
+// Invalid record set is rejected and the last good records of the key are kept.
+func (d *DnsDynConfStore) process(configKey string, container *ConfigureContainer) error {
+	rc, err := BuildResolveContainer(container)
+
+	d.keyLock.Lock()
+	defer d.keyLock.Unlock()
+	status := d.keyStatus(configKey)
+	if err != nil {
+		status.LastFailureTime = time.Now()
+		status.LastError = err.Error()
+		status.RejectedCount++
+		return err
 	}
-	for key, val := range container.TXT {
-		rc.TXT[dns.Fqdn(strings.ToLower(key))] = val
+	d.keyContainers[configKey] = rc
+	d.container.Store(d.mergeKeyContainers())
+	status.LastSuccessTime = time.Now()
+	status.LastError = ""
+	return nil
+}
+
+func (d *DnsDynConfStore) keyStatus(configKey string) *DnsDynKeyStatus {
+	status, ok := d.keyStatuses[configKey]
+	if !ok {
+		status = &DnsDynKeyStatus{Key: configKey}
+		d.keyStatuses[configKey] = status
 	}
-	for key, val := range container.SRV {
-		rc.SRV[dns.Fqdn(strings.ToLower(key))] = val
+	return status
+}
+
+// Status reports the loading result of each key
+func (d *DnsDynConfStore) Status() *DnsDynStatus {
+	d.keyLock.Lock()
+	defer d.keyLock.Unlock()
+
+	r := &DnsDynStatus{
+		Selectors: d.config.Selectors,
+		Group:     d.config.Group,
 	}
-	for key, val := range container.PTR {
-		domain := dnscore.FromIPAddressToPtrFqdn(key)
-		resolve := dns.Fqdn(strings.ToLower(val))
-		rc.PTR[domain] = resolve
+	for _, key := range d.config.Keys {
+		r.Keys = append(r.Keys, *d.keyStatus(key))
 	}
-	for key, val := range container.AAAA {
-		rc.AAAA[dns.Fqdn(strings.ToLower(key))] = val
+	return r
+}
+
+// mergeKeyContainers merges the records of all keys by the precedence of the keys.
+// Conflicting records of the latter keys are ignored with a warning.
+func (d *DnsDynConfStore) mergeKeyContainers() *ResolveContainer {
+	merged := NewResolveContainer()
+	for _, key := range d.config.Keys {
+		rc, ok := d.keyContainers[key]
+		if !ok {
+			continue
+		}
+		for _, conflict := range merged.Merge(rc) {
+			logger.Warn("ignore dns record of key:", key, "overridden by former keys:", conflict)
+		}
 	}
-	d.container.Store(rc)
-	return nil
+	return merged
 }
@@ -0,0 +1,109 @@
+package dnsdyn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func TestNewDnsDynConfStoreDefaults(t *testing.T) {
+	store := NewDnsDynConfStore(&DnsDynConfStoreConfig{Servers: []string{"127.0.0.1:8080"}})
+	if store.config.Selectors != DefaultSelectors || store.config.Group != DefaultGroup {
+		t.Fatal("default selectors and group expected:", store.config.Selectors, store.config.Group)
+	}
+	if !reflect.DeepEqual(store.config.Keys, []string{DefaultKey}) {
+		t.Fatal("default key expected:", store.config.Keys)
+	}
+
+	store = NewDnsDynConfStore(&DnsDynConfStoreConfig{
+		Servers:   []string{"127.0.0.1:8080"},
+		Selectors: "app=dns,dc=dc1,env=TEST",
+		Group:     "dns.records",
+		Keys:      []string{"team1", "team2"},
+	})
+	status := store.Status()
+	if status.Selectors != "app=dns,dc=dc1,env=TEST" || status.Group != "dns.records" {
+		t.Fatal("configured selectors and group expected:", status.Selectors, status.Group)
+	}
+	if len(status.Keys) != 2 || status.Keys[0].Key != "team1" || status.Keys[1].Key != "team2" {
+		t.Fatal("status of each key expected in order:", status.Keys)
+	}
+}
+
+func TestDnsDynConfStoreMergeKeys(t *testing.T) {
+	store := NewDnsDynConfStore(&DnsDynConfStoreConfig{Keys: []string{"primary", "secondary"}})
+	resolve := func(domain string) string {
+		val, err := store.ResolveDomain(domain, shared.DomainTypeA)
+		if err != nil {
+			return ""
+		}
+		return val
+	}
+
+	// records arriving from the latter key first are served until the former key overrides them
+	if err := store.process("secondary", &ConfigureContainer{A: map[string]string{
+		"shared.example.dns":    "127.0.0.2",
+		"secondary.example.dns": "127.0.0.3",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := resolve("shared.example.dns."); v != "127.0.0.2" {
+		t.Fatal("record of secondary key expected:", v)
+	}
+	if err := store.process("primary", &ConfigureContainer{A: map[string]string{
+		"Shared.example.dns": "127.0.0.1",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := resolve("shared.example.dns."); v != "127.0.0.1" {
+		t.Fatal("former key should take precedence:", v)
+	}
+	if v := resolve("secondary.example.dns."); v != "127.0.0.3" {
+		t.Fatal("records without conflicts should be merged:", v)
+	}
+
+	// invalid change of a key keeps its last good records and the other keys
+	if err := store.process("primary", &ConfigureContainer{A: map[string]string{"shared.example.dns": "::1"}}); err == nil {
+		t.Fatal("invalid record set should be rejected")
+	}
+	if v := resolve("shared.example.dns."); v != "127.0.0.1" {
+		t.Fatal("last good records of the key should be kept:", v)
+	}
+	status := store.Status()
+	if status.Keys[0].RejectedCount != 1 || status.Keys[0].LastError == "" || status.Keys[1].RejectedCount != 0 {
+		t.Fatal("unexpected key status:", status.Keys)
+	}
+
+	// removing the record from the former key exposes the record of the latter key
+	if err := store.process("primary", &ConfigureContainer{}); err != nil {
+		t.Fatal(err)
+	}
+	if v := resolve("shared.example.dns."); v != "127.0.0.2" {
+		t.Fatal("record of secondary key expected:", v)
+	}
+	if status := store.Status(); status.Keys[0].LastError != "" {
+		t.Fatal("error should be cleared after success:", status.Keys[0])
+	}
+}
+
+func TestResolveContainerMerge(t *testing.T) {
+	dst := NewResolveContainer()
+	dst.A["node1.example.dns."] = "127.0.0.1"
+	src := NewResolveContainer()
+	src.A["node1.example.dns."] = "127.0.0.2"
+	src.A["node2.example.dns."] = "127.0.0.3"
+	src.TXT["node1.example.dns."] = "hello"
+
+	conflicts := dst.Merge(src)
+	if len(conflicts) != 1 {
+		t.Fatal("one conflict expected:", conflicts)
+	}
+	if dst.A["node1.example.dns."] != "127.0.0.1" || dst.A["node2.example.dns."] != "127.0.0.3" || dst.TXT["node1.example.dns."] != "hello" {
+		t.Fatal("unexpected merge result:", dst)
+	}
+	// identical records are not conflicts
+	if conflicts := dst.Merge(dst); len(conflicts) != 0 {
+		t.Fatal("no conflict expected:", conflicts)
+	}
+}
@@ -0,0 +1,136 @@
+package dnsdyn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// DirStore loads records from all the toml/json/yaml files in a directory, e.g. one file per team.
+// Files are merged in file name order. A record conflicting with a former file is ignored and reported.
+// An invalid file is rejected as a whole and its last good records are kept.
+type DirStore struct {
+	*sourceStore
+
+	dir string
+
+	fileContainers map[string]*ResolveContainer
+	fileModTimes   map[string]time.Time
+	fileErrors     map[string]error
+}
+
+func NewDirStore(dir string, interval time.Duration) *DirStore {
+	return &DirStore{
+		sourceStore:    newSourceStore("dir:"+dir, interval),
+		dir:            dir,
+		fileContainers: make(map[string]*ResolveContainer),
+		fileModTimes:   make(map[string]time.Time),
+		fileErrors:     make(map[string]error),
+	}
+}
+
+// Startup loads the files and starts watching the directory.
+// Only an unreadable directory fails the startup. Invalid files are rejected and the other files are served.
+func (d *DirStore) Startup() error {
+	if loaded, err := d.load(); err != nil {
+		d.reject(err)
+		if !loaded {
+			return err
+		}
+	}
+	go d.checkLoop(func() {
+		loaded, err := d.load()
+		if err != nil {
+			d.reject(err)
+		} else if loaded {
+			logger.Info("dns record directory reloaded:", d.dir)
+		}
+	})
+	return nil
+}
+
+// load returns true once the records are replaced, together with the errors of rejected files and conflicts
+func (d *DirStore) load() (bool, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	present := make(map[string]struct{})
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		format, ok := FormatFromFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		name := entry.Name()
+		present[name] = struct{}{}
+		info, err := entry.Info()
+		if err != nil {
+			return false, err
+		}
+		if modTime, ok := d.fileModTimes[name]; ok && modTime.Equal(info.ModTime()) {
+			continue
+		}
+		d.fileModTimes[name] = info.ModTime()
+		changed = true
+
+		rc, err := d.loadFile(filepath.Join(d.dir, name), format)
+		if err != nil {
+			d.fileErrors[name] = err
+			continue
+		}
+		delete(d.fileErrors, name)
+		d.fileContainers[name] = rc
+	}
+	// removed files
+	for name := range d.fileModTimes {
+		if _, ok := present[name]; !ok {
+			delete(d.fileModTimes, name)
+			delete(d.fileContainers, name)
+			delete(d.fileErrors, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	var names []string
+	for name := range d.fileContainers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	merged := NewResolveContainer()
+	var errs []error
+	for _, name := range names {
+		for _, conflict := range merged.Merge(d.fileContainers[name]) {
+			errs = append(errs, errors.New(name+": "+conflict.Error()))
+		}
+	}
+	for name, err := range d.fileErrors {
+		errs = append(errs, errors.New(name+": "+err.Error()))
+	}
+	d.accept(merged)
+	if len(errs) > 0 {
+		return true, errors.Join(errs...)
+	}
+	return true, nil
+}
+
+func (d *DirStore) loadFile(path, format string) (*ResolveContainer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	container, err := DecodeConfigureContainer(format, data)
+	if err != nil {
+		return nil, err
+	}
+	return BuildResolveContainer(container)
+}
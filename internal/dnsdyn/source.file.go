@@ -0,0 +1,76 @@
+package dnsdyn
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// FileStore loads records from a local toml/json/yaml file and reloads it once modified
+type FileStore struct {
+	*sourceStore
+
+	path    string
+	format  string
+	modTime time.Time
+}
+
+// NewFileStore creates the store. The format is detected from the file extension when it is empty.
+func NewFileStore(path, format string, interval time.Duration) (*FileStore, error) {
+	if format == "" {
+		f, ok := FormatFromFileName(path)
+		if !ok {
+			return nil, errors.New("unknown record format of file:" + path)
+		}
+		format = f
+	}
+	return &FileStore{
+		sourceStore: newSourceStore("file:"+path, interval),
+		path:        path,
+		format:      format,
+	}, nil
+}
+
+// Startup loads the file and starts watching modifications
+func (f *FileStore) Startup() error {
+	if _, err := f.load(); err != nil {
+		f.reject(err)
+		return err
+	}
+	go f.checkLoop(func() {
+		loaded, err := f.load()
+		if err != nil {
+			f.reject(err)
+		} else if loaded {
+			logger.Info("dns record file reloaded:", f.path)
+		}
+	})
+	return nil
+}
+
+func (f *FileStore) load() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return false, nil
+	}
+	// record the modification time to avoid reporting the same failure repeatedly
+	f.modTime = info.ModTime()
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	container, err := DecodeConfigureContainer(f.format, data)
+	if err != nil {
+		return false, err
+	}
+	rc, err := BuildResolveContainer(container)
+	if err != nil {
+		return false, err
+	}
+	f.accept(rc)
+	return true, nil
+}
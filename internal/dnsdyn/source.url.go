@@ -0,0 +1,116 @@
+package dnsdyn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	urlSourceTimeout = 10 * time.Second
+	// DefaultUrlSourceMaxSize is the default limit of the response body size
+	DefaultUrlSourceMaxSize = 16 * 1024 * 1024
+)
+
+// UrlStore polls records from a http(s) url.
+// ETag of the response is sent back via If-None-Match so unchanged records are not transferred again.
+type UrlStore struct {
+	*sourceStore
+
+	// MaxSize limits the size of the response body in bytes. Larger responses are rejected.
+	MaxSize int64
+
+	url    string
+	format string
+	etag   string
+	client *http.Client
+}
+
+// NewUrlStore creates the store. The format is detected from the url path when it is empty and toml is the default.
+func NewUrlStore(rawUrl, format string, interval time.Duration) (*UrlStore, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("unsupported url scheme:" + rawUrl)
+	}
+	if format == "" {
+		if f, ok := FormatFromFileName(u.Path); ok {
+			format = f
+		} else {
+			format = FormatToml
+		}
+	}
+	return &UrlStore{
+		sourceStore: newSourceStore("url:"+rawUrl, interval),
+		MaxSize:     DefaultUrlSourceMaxSize,
+		url:         rawUrl,
+		format:      format,
+		client:      &http.Client{Timeout: urlSourceTimeout},
+	}, nil
+}
+
+// Startup loads the records and starts polling
+func (u *UrlStore) Startup() error {
+	if _, err := u.load(); err != nil {
+		u.reject(err)
+		return err
+	}
+	go u.checkLoop(func() {
+		loaded, err := u.load()
+		if err != nil {
+			u.reject(err)
+		} else if loaded {
+			logger.Info("dns record url reloaded:", u.url)
+		}
+	})
+	return nil
+}
+
+func (u *UrlStore) load() (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, u.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if u.etag != "" {
+		req.Header.Set("If-None-Match", u.etag)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, errors.New(fmt.Sprint("fetch dns records failed, status:", resp.StatusCode))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, u.MaxSize+1))
+	if err != nil {
+		return false, err
+	}
+	if int64(len(data)) > u.MaxSize {
+		return false, errors.New(fmt.Sprint("dns records exceed the max size:", u.MaxSize))
+	}
+	container, err := DecodeConfigureContainer(u.format, data)
+	if err != nil {
+		return false, err
+	}
+	rc, err := BuildResolveContainer(container)
+	if err != nil {
+		return false, err
+	}
+	u.accept(rc)
+	u.etag = resp.Header.Get("ETag")
+	return true, nil
+}
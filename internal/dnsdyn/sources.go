@@ -0,0 +1,113 @@
+package dnsdyn
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const DefaultSourceCheckInterval = 5 * time.Second
+
+type SourceStatus struct {
+	Source          string    `json:"source"`
+	LastSuccessTime time.Time `json:"last_success_time"`
+	LastFailureTime time.Time `json:"last_failure_time"`
+	LastError       string    `json:"last_error,omitempty"`
+	RejectedCount   int       `json:"rejected_count"`
+}
+
+// sourceStore serves the last good records loaded from a source.
+// Concrete sources check changes periodically and replace the records.
+type sourceStore struct {
+	container *atomic.Value
+
+	status     SourceStatus
+	statusLock sync.Mutex
+
+	interval time.Duration
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func newSourceStore(source string, interval time.Duration) *sourceStore {
+	if interval <= 0 {
+		interval = DefaultSourceCheckInterval
+	}
+	rcVal := new(atomic.Value)
+	rcVal.Store(NewResolveContainer())
+	return &sourceStore{
+		container: rcVal,
+		status:    SourceStatus{Source: source},
+		interval:  interval,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+func (s *sourceStore) GetContainer() *ResolveContainer {
+	return s.container.Load().(*ResolveContainer)
+}
+
+func (s *sourceStore) ResolveDomain(domain string, domainType shared.DomainType) (string, error) {
+	domain = strings.ToLower(domain)
+
+	if val, ok := s.GetContainer().Resolve(domain, domainType); ok {
+		return val, nil
+	}
+	return "", shared.ErrStorageNotFound
+}
+
+func (s *sourceStore) PutDomain(domain, resolve string, domainType shared.DomainType) {
+	panic("unsupported")
+}
+
+func (s *sourceStore) Status() SourceStatus {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	return s.status
+}
+
+func (s *sourceStore) Stop() error {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	return nil
+}
+
+func (s *sourceStore) accept(rc *ResolveContainer) {
+	s.container.Store(rc)
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status.LastSuccessTime = time.Now()
+	s.status.LastError = ""
+}
+
+func (s *sourceStore) reject(err error) {
+	logger.Error("reject dns records and keep the last good records. source:", s.status.Source, "error:", err)
+
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status.LastFailureTime = time.Now()
+	s.status.LastError = err.Error()
+	s.status.RejectedCount++
+}
+
+// checkLoop invokes check periodically until the store is stopped
+func (s *sourceStore) checkLoop(check func()) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+var _ dnscore.DnsStorage = new(sourceStore)
@@ -0,0 +1,156 @@
+package dnsdyn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func TestDecodeConfigureContainer(t *testing.T) {
+	for format, data := range map[string]string{
+		FormatToml: "[A]\n\"node1.example.dns\"=\"127.0.0.1\"\n",
+		FormatJson: `{"A":{"node1.example.dns":"127.0.0.1"}}`,
+		FormatYaml: "A:\n  node1.example.dns: 127.0.0.1\n",
+	} {
+		container, err := DecodeConfigureContainer(format, []byte(data))
+		if err != nil {
+			t.Fatal(format, err)
+		}
+		if container.A["node1.example.dns"] != "127.0.0.1" {
+			t.Fatal(format, "record not match")
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	if err := os.WriteFile(path, []byte(`{"A":{"node1.example.dns":"127.0.0.1"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+
+	// invalid change is rejected
+	if err := os.WriteFile(path, []byte(`{"A":{"node1.example.dns":"::1"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.load(); err == nil {
+		t.Fatal("rejection expected")
+	}
+	if val, err := store.ResolveDomain("node1.example.dns.", shared.DomainTypeA); err != nil || val != "127.0.0.1" {
+		t.Fatal("last good record should be kept:", val, err)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.toml"), []byte("[A]\n\"node1.example.dns\"=\"127.0.0.1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("A:\n  node1.example.dns: 127.0.0.2\n  node2.example.dns: 127.0.0.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDirStore(dir, time.Second)
+	if _, err := store.load(); err == nil {
+		t.Fatal("conflict expected")
+	}
+	if val, _ := store.ResolveDomain("node1.example.dns.", shared.DomainTypeA); val != "127.0.0.1" {
+		t.Fatal("former file should take precedence:", val)
+	}
+	if val, _ := store.ResolveDomain("node2.example.dns.", shared.DomainTypeA); val != "127.0.0.3" {
+		t.Fatal("record of latter file not found:", val)
+	}
+}
+
+func TestUrlStoreETag(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("[A]\n\"node1.example.dns\"=\"127.0.0.1\"\n"))
+	}))
+	defer server.Close()
+
+	store, err := NewUrlStore(server.URL+"/records", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := store.load(); err != nil || !loaded {
+		t.Fatal("first load failed:", err)
+	}
+	if loaded, err := store.load(); err != nil || loaded {
+		t.Fatal("not modified expected:", err)
+	}
+	if val, _ := store.ResolveDomain("node1.example.dns.", shared.DomainTypeA); val != "127.0.0.1" {
+		t.Fatal("record not match:", val)
+	}
+}
+
+func TestUrlStoreMaxSize(t *testing.T) {
+	records := "[A]\n\"node1.example.dns\"=\"127.0.0.1\"\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(records))
+	}))
+	defer server.Close()
+
+	store, err := NewUrlStore(server.URL+"/records.toml", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.MaxSize = 64
+	if err := store.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Stop()
+
+	records = "[A]\n\"node1.example.dns\"=\"127.0.0.2\"\n\"node2.example.dns\"=\"127.0.0.3\"\n"
+	if _, err := store.load(); err == nil {
+		t.Fatal("oversized response should be rejected")
+	}
+	if val, _ := store.ResolveDomain("node1.example.dns.", shared.DomainTypeA); val != "127.0.0.1" {
+		t.Fatal("last good record should be kept:", val)
+	}
+}
+
+func TestDirStoreStartupWithInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.toml"), []byte("[A]\n\"node1.example.dns\"=\"::1\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{"A":{"node2.example.dns":"127.0.0.2"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDirStore(dir, time.Second)
+	if err := store.Startup(); err != nil {
+		t.Fatal("invalid file should not fail the startup:", err)
+	}
+	defer store.Stop()
+
+	if val, _ := store.ResolveDomain("node2.example.dns.", shared.DomainTypeA); val != "127.0.0.2" {
+		t.Fatal("records of valid files should be served:", val)
+	}
+	if status := store.Status(); status.RejectedCount != 1 || status.LastError == "" {
+		t.Fatal("invalid file should be reported:", status)
+	}
+	if err := NewDirStore(filepath.Join(dir, "missing"), time.Second).Startup(); err == nil {
+		t.Fatal("unreadable directory should fail the startup")
+	}
+}
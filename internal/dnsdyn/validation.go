@@ -0,0 +1,64 @@
+package dnsdyn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+// BuildResolveContainer normalizes and validates all the records.
+// Any invalid record or conflict rejects the whole record set and all the failures are returned.
+func BuildResolveContainer(container *ConfigureContainer) (*ResolveContainer, error) {
+	if container == nil {
+		return nil, errors.New("empty record set")
+	}
+
+	rc := NewResolveContainer()
+	var errs []error
+	put := func(typeName string, domainType shared.DomainType, dst map[string]string, domain, value string) {
+		if err := dnscore.ValidateRecord(domainType, domain, value); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		// different keys of the same record after normalization
+		if exist, ok := dst[domain]; ok && exist != value {
+			errs = append(errs, fmt.Errorf("conflict %s records:%s->[%s] and [%s]", typeName, domain, exist, value))
+			return
+		}
+		dst[domain] = value
+	}
+
+	for key, val := range container.A {
+		put("A", shared.DomainTypeA, rc.A, dns.Fqdn(strings.ToLower(key)), val)
+	}
+	for key, val := range container.TXT {
+		put("TXT", shared.DomainTypeTxt, rc.TXT, dns.Fqdn(strings.ToLower(key)), val)
+	}
+	for key, val := range container.SRV {
+		put("SRV", shared.DomainTypeSrv, rc.SRV, dns.Fqdn(strings.ToLower(key)), val)
+	}
+	for key, val := range container.PTR {
+		if !dnscore.IsValidIPAddress(key) {
+			errs = append(errs, fmt.Errorf("invalid IP address of PTR record:%s", key))
+			continue
+		}
+		if strings.TrimSpace(val) == "" {
+			errs = append(errs, fmt.Errorf("empty PTR record:%s", key))
+			continue
+		}
+		put("PTR", shared.DomainTypePtr, rc.PTR, dnscore.FromIPAddressToPtrFqdn(key), dns.Fqdn(strings.ToLower(val)))
+	}
+	for key, val := range container.AAAA {
+		put("AAAA", shared.DomainTypeAAAA, rc.AAAA, dns.Fqdn(strings.ToLower(key)), val)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rc, nil
+}
@@ -0,0 +1,55 @@
+package dnsdyn
+
+import (
+	"testing"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func TestBuildResolveContainer(t *testing.T) {
+	rc, err := BuildResolveContainer(&ConfigureContainer{
+		A:   map[string]string{"Node1.example.dns": "127.0.0.1"},
+		PTR: map[string]string{"8.8.8.8": "demo1.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.A["node1.example.dns."] != "127.0.0.1" {
+		t.Fatal("A record not match")
+	}
+	if rc.PTR["8.8.8.8.in-addr.arpa."] != "demo1.example.com." {
+		t.Fatal("PTR record not match")
+	}
+
+	if _, err := BuildResolveContainer(&ConfigureContainer{
+		A: map[string]string{"node1.example.dns": "127.0.0.1", "NODE1.example.dns.": "127.0.0.2"},
+	}); err == nil {
+		t.Fatal("conflict expected")
+	}
+	if _, err := BuildResolveContainer(&ConfigureContainer{
+		PTR: map[string]string{"not-an-ip": "demo1.example.com"},
+	}); err == nil {
+		t.Fatal("invalid PTR expected")
+	}
+}
+
+func TestProcessKeepLastGood(t *testing.T) {
+	store := NewDnsDynConfStore(&DnsDynConfStoreConfig{})
+	if err := store.process(DefaultKey, &ConfigureContainer{
+		A: map[string]string{"node1.example.dns": "127.0.0.1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.process(DefaultKey, &ConfigureContainer{
+		A: map[string]string{"node1.example.dns": "127.0.0.2", "node2.example.dns": "999.0.0.1"},
+	}); err == nil {
+		t.Fatal("rejection expected")
+	}
+	if val, err := store.ResolveDomain("node1.example.dns.", shared.DomainTypeA); err != nil || val != "127.0.0.1" {
+		t.Fatal("last good record should be kept:", val, err)
+	}
+	status := store.Status()
+	if len(status.Keys) != 1 || status.Keys[0].RejectedCount != 1 || status.Keys[0].LastError == "" {
+		t.Fatal("status not match:", status)
+	}
+}
@@ -0,0 +1,232 @@
+package dnsrpz
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+const (
+	DefaultReloadInterval = 5 * time.Second
+
+	SourceTypeRpz     = "rpz"
+	SourceTypeDomains = "domains"
+
+	defaultLocalDataTTL = 60
+)
+
+// Source is a policy file
+type Source struct {
+	// Type is rpz or domains
+	Type     string
+	Location string
+	// Action of all the domains in a domain list
+	Action Action
+}
+
+type PolicyStatus struct {
+	Sources map[string]int    `json:"sources"`
+	Hits    map[Action]uint64 `json:"hits"`
+}
+
+// PolicyEngine checks queries against the policy sources before resolution.
+// Sources are checked in order and the first matched rule applies.
+// Files are reloaded once modified and the last good policies are kept on errors.
+type PolicyEngine struct {
+	sources        []*Source
+	reloadInterval time.Duration
+
+	filePolicies map[string]*PolicySet
+	fileModTimes map[string]time.Time
+
+	// []*PolicySet in the order of sources
+	policies *atomic.Value
+
+	hits     map[Action]*atomic.Uint64
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func NewPolicyEngine(sources []*Source) *PolicyEngine {
+	policiesVal := new(atomic.Value)
+	policiesVal.Store([]*PolicySet{})
+	hits := make(map[Action]*atomic.Uint64)
+	for _, action := range []Action{ActionNxDomain, ActionNoData, ActionPassthru, ActionDrop, ActionLocalData} {
+		hits[action] = new(atomic.Uint64)
+	}
+	return &PolicyEngine{
+		sources:        sources,
+		reloadInterval: DefaultReloadInterval,
+		filePolicies:   make(map[string]*PolicySet),
+		fileModTimes:   make(map[string]time.Time),
+		policies:       policiesVal,
+		hits:           hits,
+		stopCh:         make(chan struct{}),
+	}
+}
+
+// Startup loads all the policy files and starts watching file changes.
+// Any parse error during startup is returned.
+func (p *PolicyEngine) Startup() error {
+	for _, source := range p.sources {
+		if _, err := p.loadSource(source); err != nil {
+			return err
+		}
+	}
+	p.rebuild()
+	go p.watch()
+	return nil
+}
+
+func (p *PolicyEngine) Stop() error {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+	return nil
+}
+
+func (p *PolicyEngine) Status() PolicyStatus {
+	status := PolicyStatus{
+		Sources: make(map[string]int),
+		Hits:    make(map[Action]uint64),
+	}
+	policies := p.getPolicies()
+	for i, source := range p.sources {
+		status.Sources[source.Location] = policies[i].Size()
+	}
+	for action, counter := range p.hits {
+		status.Hits[action] = counter.Load()
+	}
+	return status
+}
+
+func (p *PolicyEngine) watch() {
+	ticker := time.NewTicker(p.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		changed := false
+		for _, source := range p.sources {
+			reloaded, err := p.loadSource(source)
+			if err != nil {
+				// keep the last good policies of the file
+				logger.Error("reload policy file failed:", err)
+				continue
+			}
+			if reloaded {
+				logger.Info("policy file reloaded:", source.Location)
+				changed = true
+			}
+		}
+		if changed {
+			p.rebuild()
+		}
+	}
+}
+
+// loadSource parses the file when its modification time changes
+func (p *PolicyEngine) loadSource(source *Source) (bool, error) {
+	info, err := os.Stat(source.Location)
+	if err != nil {
+		return false, err
+	}
+	if modTime, ok := p.fileModTimes[source.Location]; ok && modTime.Equal(info.ModTime()) {
+		return false, nil
+	}
+	// record the modification time to avoid reporting the same error repeatedly
+	p.fileModTimes[source.Location] = info.ModTime()
+
+	var policies *PolicySet
+	switch source.Type {
+	case SourceTypeRpz:
+		policies, err = ParseRpzFile(source.Location)
+	default:
+		policies, err = ParseDomainListFile(source.Location, source.Action)
+	}
+	if err != nil {
+		return false, err
+	}
+	p.filePolicies[source.Location] = policies
+	return true, nil
+}
+
+func (p *PolicyEngine) rebuild() {
+	policies := make([]*PolicySet, 0, len(p.sources))
+	for _, source := range p.sources {
+		policySet, ok := p.filePolicies[source.Location]
+		if !ok {
+			policySet = newPolicySet()
+		}
+		policies = append(policies, policySet)
+	}
+	p.policies.Store(policies)
+}
+
+func (p *PolicyEngine) getPolicies() []*PolicySet {
+	return p.policies.Load().([]*PolicySet)
+}
+
+// Match returns the first matched rule and its source
+func (p *PolicyEngine) Match(qname string) (*Rule, *Source) {
+	qname = strings.ToLower(qname)
+	for i, policySet := range p.getPolicies() {
+		if rule := policySet.Match(qname); rule != nil {
+			return rule, p.sources[i]
+		}
+	}
+	return nil, nil
+}
+
+func (p *PolicyEngine) ApplyPolicy(m *dns.Msg, ctx *dnscore.RequestContext) (*dns.Msg, error) {
+	question := m.Question[0]
+	rule, source := p.Match(question.Name)
+	if rule == nil {
+		return nil, nil
+	}
+
+	p.hits[rule.Action].Add(1)
+	ctx.EnterHandler("RpzPolicy")
+	ctx.AddTraceInfo("RpzPolicy->" + string(rule.Action) + ":" + rule.Trigger)
+	logger.Info("policy hit. client:", ctx.ClientIP, "name:", question.Name, "type:", dns.Type(question.Qtype).String(),
+		"trigger:", rule.Trigger, "action:", rule.Action, "source:", source.Location)
+
+	reply := new(dns.Msg)
+	switch rule.Action {
+	case ActionPassthru:
+		return nil, nil
+	case ActionDrop:
+		return nil, dnscore.ErrDoNotRespondResult
+	case ActionNxDomain:
+		return reply.SetRcode(m, dns.RcodeNameError), nil
+	case ActionNoData:
+		return reply.SetReply(m), nil
+	default:
+		reply.SetReply(m)
+		for _, rr := range rule.LocalData {
+			rrType := rr.Header().Rrtype
+			if rrType != question.Qtype && rrType != dns.TypeCNAME {
+				continue
+			}
+			answer := dns.Copy(rr)
+			answer.Header().Name = question.Name
+			if answer.Header().Ttl == 0 {
+				answer.Header().Ttl = defaultLocalDataTTL
+			}
+			reply.Answer = append(reply.Answer, answer)
+		}
+		return reply, nil
+	}
+}
+
+var _ dnscore.DnsPolicy = new(PolicyEngine)
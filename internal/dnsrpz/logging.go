@@ -0,0 +1,5 @@
+package dnsrpz
+
+import "github.com/meidoworks/nekoq-bootstrap/logging"
+
+var logger = logging.Manager.GetLogger("dnsrpz")
@@ -0,0 +1,234 @@
+package dnsrpz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+type Action string
+
+const (
+	ActionNxDomain  Action = "nxdomain"
+	ActionNoData    Action = "nodata"
+	ActionPassthru  Action = "passthru"
+	ActionDrop      Action = "drop"
+	ActionLocalData Action = "local-data"
+)
+
+// ParseAction converts the action name of domain lists
+func ParseAction(name string) (Action, error) {
+	switch action := Action(strings.ToLower(name)); action {
+	case "":
+		return ActionNxDomain, nil
+	case ActionNxDomain, ActionNoData, ActionPassthru, ActionDrop:
+		return action, nil
+	default:
+		return "", errors.New("unknown policy action:" + name)
+	}
+}
+
+// Rule is the policy of a trigger name
+type Rule struct {
+	Trigger string
+	Action  Action
+	// LocalData holds the records responded for ActionLocalData
+	LocalData []dns.RR
+}
+
+// PolicySet holds the rules of a policy source indexed by lower case fqdn.
+// Wildcard triggers `*.example.com.` are stored by the parent name `example.com.`
+type PolicySet struct {
+	exact    map[string]*Rule
+	wildcard map[string]*Rule
+}
+
+func newPolicySet() *PolicySet {
+	return &PolicySet{
+		exact:    make(map[string]*Rule),
+		wildcard: make(map[string]*Rule),
+	}
+}
+
+func (p *PolicySet) Size() int {
+	return len(p.exact) + len(p.wildcard)
+}
+
+// Match finds the rule of the exact name first, then the wildcard rule of the closest enclosing name
+func (p *PolicySet) Match(qname string) *Rule {
+	if rule, ok := p.exact[qname]; ok {
+		return rule
+	}
+	for name := qname; ; {
+		idx := strings.IndexByte(name, '.')
+		if idx < 0 || idx == len(name)-1 {
+			return nil
+		}
+		name = name[idx+1:]
+		if rule, ok := p.wildcard[name]; ok {
+			return rule
+		}
+	}
+}
+
+func (p *PolicySet) rule(trigger string) *Rule {
+	m, name := p.exact, trigger
+	if strings.HasPrefix(trigger, "*.") {
+		m, name = p.wildcard, trigger[2:]
+	}
+	rule, ok := m[name]
+	if !ok {
+		rule = &Rule{Trigger: trigger}
+		m[name] = rule
+	}
+	return rule
+}
+
+func (p *PolicySet) addAction(trigger string, action Action) error {
+	rule := p.rule(trigger)
+	if rule.Action != "" && rule.Action != action {
+		return fmt.Errorf("conflicting policy actions of %s: %s and %s", trigger, rule.Action, action)
+	}
+	rule.Action = action
+	return nil
+}
+
+func (p *PolicySet) addLocalData(trigger string, rr dns.RR) error {
+	rule := p.rule(trigger)
+	if rule.Action != "" && rule.Action != ActionLocalData {
+		return fmt.Errorf("conflicting policy actions of %s: %s and %s", trigger, rule.Action, ActionLocalData)
+	}
+	rule.Action = ActionLocalData
+	rule.LocalData = append(rule.LocalData, rr)
+	return nil
+}
+
+// ParseRpzFile parses a response policy zone file
+func ParseRpzFile(file string) (*PolicySet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	return ParseRpz(f, file)
+}
+
+// ParseRpz parses a response policy zone. Only QNAME triggers are supported.
+// The zone apex is the owner of the SOA record and trigger names are relative to it.
+// CNAME targets: `.` for NXDOMAIN, `*.` for NODATA, `rpz-passthru.` for PASSTHRU, `rpz-drop.` for DROP.
+// Other records are local data responded in place of the original answers.
+func ParseRpz(r io.Reader, file string) (*PolicySet, error) {
+	var origin string
+	var rrs []dns.RR
+	zp := dns.NewZoneParser(r, ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if soa, isSoa := rr.(*dns.SOA); isSoa && origin == "" {
+			origin = strings.ToLower(soa.Hdr.Name)
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	if origin == "" {
+		return nil, errors.New("SOA record is required in rpz file:" + file)
+	}
+
+	policies := newPolicySet()
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		if owner == origin {
+			// zone apex records
+			continue
+		}
+		if !strings.HasSuffix(owner, "."+origin) {
+			logger.Warn("record out of rpz zone is skipped:", file, "name:", owner)
+			continue
+		}
+		trigger := strings.TrimSuffix(owner, origin)
+		if isUnsupportedTrigger(trigger) {
+			logger.Warn("unsupported rpz trigger is skipped:", file, "name:", owner)
+			continue
+		}
+
+		var err error
+		if cname, isCname := rr.(*dns.CNAME); isCname {
+			switch strings.ToLower(cname.Target) {
+			case ".":
+				err = policies.addAction(trigger, ActionNxDomain)
+			case "*.":
+				err = policies.addAction(trigger, ActionNoData)
+			case "rpz-passthru.":
+				err = policies.addAction(trigger, ActionPassthru)
+			case "rpz-drop.":
+				err = policies.addAction(trigger, ActionDrop)
+			default:
+				if strings.HasPrefix(strings.ToLower(cname.Target), "rpz-") {
+					logger.Warn("unsupported rpz action is skipped:", file, "name:", owner, "action:", cname.Target)
+					continue
+				}
+				err = policies.addLocalData(trigger, rr)
+			}
+		} else {
+			err = policies.addLocalData(trigger, rr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return policies, nil
+}
+
+func isUnsupportedTrigger(trigger string) bool {
+	for _, suffix := range []string{".rpz-ip.", ".rpz-client-ip.", ".rpz-nsdname.", ".rpz-nsip."} {
+		if strings.HasSuffix(trigger, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDomainListFile parses a plain domain list
+func ParseDomainListFile(file string, action Action) (*PolicySet, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	return ParseDomainList(f, file, action)
+}
+
+// ParseDomainList parses one domain per line with the same action.
+// Wildcard `*.example.com` matches the subdomains. Empty lines and lines starting with `#` are ignored.
+func ParseDomainList(r io.Reader, file string, action Action) (*PolicySet, error) {
+	policies := newPolicySet()
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domain := dns.Fqdn(strings.ToLower(line))
+		if _, ok := dns.IsDomainName(domain); !ok {
+			return nil, fmt.Errorf("%s:%d: invalid domain name:%s", file, lineNo, line)
+		}
+		if err := policies.addAction(domain, action); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
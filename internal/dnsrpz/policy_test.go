@@ -0,0 +1,113 @@
+package dnsrpz
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+)
+
+const testRpz = `$ORIGIN rpz.example.
+$TTL 300
+@                  IN SOA localhost. root.localhost. 1 3600 600 86400 300
+@                  IN NS  localhost.
+bad.example.com    IN CNAME .
+*.bad.example.com  IN CNAME .
+empty.example.com  IN CNAME *.
+ok.bad.example.com IN CNAME rpz-passthru.
+drop.example.com   IN CNAME rpz-drop.
+local.example.com  IN A     10.0.0.1
+local.example.com  IN A     10.0.0.2
+alias.example.com  IN CNAME www.example.net.
+32.1.0.0.127.rpz-ip IN CNAME .
+`
+
+func TestParseRpz(t *testing.T) {
+	policies, err := ParseRpz(strings.NewReader(testRpz), "test.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]Action{
+		"bad.example.com.":     ActionNxDomain,
+		"a.b.bad.example.com.": ActionNxDomain,
+		"empty.example.com.":   ActionNoData,
+		"ok.bad.example.com.":  ActionPassthru,
+		"drop.example.com.":    ActionDrop,
+		"local.example.com.":   ActionLocalData,
+		"alias.example.com.":   ActionLocalData,
+	}
+	for name, action := range cases {
+		rule := policies.Match(name)
+		if rule == nil || rule.Action != action {
+			t.Fatal("unexpected rule:", name, rule)
+		}
+	}
+	if rule := policies.Match("example.com."); rule != nil {
+		t.Fatal("unexpected rule:", rule)
+	}
+	if size := policies.Size(); size != 7 {
+		t.Fatal("unexpected size:", size)
+	}
+
+	if _, err := ParseRpz(strings.NewReader("bad.example.com IN CNAME .\n"), "nosoa.zone"); err == nil {
+		t.Fatal("rpz without SOA should fail")
+	}
+	if _, err := ParseRpz(strings.NewReader(testRpz+"local.example.com IN CNAME .\n"), "conflict.zone"); err == nil {
+		t.Fatal("conflicting actions should fail")
+	}
+}
+
+func TestPolicyEngine(t *testing.T) {
+	dir := t.TempDir()
+	rpzFile := filepath.Join(dir, "rpz.zone")
+	listFile := filepath.Join(dir, "allow.txt")
+	if err := os.WriteFile(rpzFile, []byte(testRpz), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(listFile, []byte("# allowed\nlocal.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine := NewPolicyEngine([]*Source{
+		{Type: SourceTypeDomains, Location: listFile, Action: ActionPassthru},
+		{Type: SourceTypeRpz, Location: rpzFile},
+	})
+	if err := engine.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop()
+
+	apply := func(name string, qtype uint16) (*dns.Msg, error) {
+		return engine.ApplyPolicy(new(dns.Msg).SetQuestion(name, qtype), dnscore.NewRequestContext())
+	}
+
+	if reply, err := apply("x.bad.example.com.", dns.TypeA); err != nil || reply.Rcode != dns.RcodeNameError {
+		t.Fatal("expect NXDOMAIN:", reply, err)
+	}
+	if reply, err := apply("empty.example.com.", dns.TypeA); err != nil || reply.Rcode != dns.RcodeSuccess || len(reply.Answer) != 0 {
+		t.Fatal("expect NODATA:", reply, err)
+	}
+	if _, err := apply("drop.example.com.", dns.TypeA); err != dnscore.ErrDoNotRespondResult {
+		t.Fatal("expect DROP:", err)
+	}
+	// the domain list listed first takes precedence
+	if reply, err := apply("local.example.com.", dns.TypeA); err != nil || reply != nil {
+		t.Fatal("expect PASSTHRU:", reply, err)
+	}
+	reply, err := apply("alias.example.com.", dns.TypeAAAA)
+	if err != nil || len(reply.Answer) != 1 || reply.Answer[0].(*dns.CNAME).Target != "www.example.net." ||
+		reply.Answer[0].Header().Name != "alias.example.com." {
+		t.Fatal("expect CNAME rewrite:", reply, err)
+	}
+	if reply, err := apply("www.example.com.", dns.TypeA); err != nil || reply != nil {
+		t.Fatal("expect no policy:", reply, err)
+	}
+
+	status := engine.Status()
+	if status.Hits[ActionNxDomain] != 1 || status.Hits[ActionPassthru] != 1 || status.Sources[rpzFile] != 7 {
+		t.Fatal("unexpected status:", status)
+	}
+}
@@ -0,0 +1,167 @@
+package dnszone
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const DefaultReloadInterval = 5 * time.Second
+
+// ZoneFileStore serves records loaded from zone files and reloads the files once they are modified.
+// When several files contain the same rrset, the file listed first wins.
+type ZoneFileStore struct {
+	files          []string
+	reloadInterval time.Duration
+
+	// last good records and modification time of each file
+	fileRecords  map[string]ZoneRecords
+	fileModTimes map[string]time.Time
+
+	records *atomic.Value
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func NewZoneFileStore(files []string) *ZoneFileStore {
+	recordsVal := new(atomic.Value)
+	recordsVal.Store(ZoneRecords{})
+	return &ZoneFileStore{
+		files:          files,
+		reloadInterval: DefaultReloadInterval,
+		fileRecords:    make(map[string]ZoneRecords),
+		fileModTimes:   make(map[string]time.Time),
+		records:        recordsVal,
+		stopCh:         make(chan struct{}),
+	}
+}
+
+// Startup loads all the zone files and starts watching file changes.
+// Any parse error during startup is returned.
+func (z *ZoneFileStore) Startup() error {
+	for _, file := range z.files {
+		if _, err := z.loadFile(file); err != nil {
+			return err
+		}
+	}
+	z.rebuild()
+	go z.watch()
+	return nil
+}
+
+func (z *ZoneFileStore) Stop() error {
+	z.stopOnce.Do(func() {
+		close(z.stopCh)
+	})
+	return nil
+}
+
+func (z *ZoneFileStore) watch() {
+	ticker := time.NewTicker(z.reloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-z.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		changed := false
+		for _, file := range z.files {
+			reloaded, err := z.loadFile(file)
+			if err != nil {
+				// keep serving the last good records of the file
+				logger.Error("reload zone file failed:", err)
+				continue
+			}
+			if reloaded {
+				logger.Info("zone file reloaded:", file)
+				changed = true
+			}
+		}
+		if changed {
+			z.rebuild()
+		}
+	}
+}
+
+// loadFile parses the file when its modification time changes
+func (z *ZoneFileStore) loadFile(file string) (bool, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	if modTime, ok := z.fileModTimes[file]; ok && modTime.Equal(info.ModTime()) {
+		return false, nil
+	}
+	records, err := ParseZoneFile(file)
+	if err != nil {
+		// record the modification time to avoid reporting the same error repeatedly
+		z.fileModTimes[file] = info.ModTime()
+		return false, err
+	}
+	z.fileModTimes[file] = info.ModTime()
+	z.fileRecords[file] = records
+	return true, nil
+}
+
+func (z *ZoneFileStore) rebuild() {
+	merged := ZoneRecords{}
+	for _, file := range z.files {
+		for domainType, sub := range z.fileRecords[file] {
+			mergedSub, ok := merged[domainType]
+			if !ok {
+				mergedSub = make(map[string]*ZoneRRSet)
+				merged[domainType] = mergedSub
+			}
+			for domain, rrset := range sub {
+				if _, exists := mergedSub[domain]; exists {
+					continue
+				}
+				mergedSub[domain] = rrset
+			}
+		}
+	}
+	z.records.Store(merged)
+}
+
+func (z *ZoneFileStore) getRecords() ZoneRecords {
+	return z.records.Load().(ZoneRecords)
+}
+
+func (z *ZoneFileStore) ResolveDomain(domain string, domainType shared.DomainType) (string, error) {
+	values, err := z.ResolveDomainRRSet(domain, domainType)
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+func (z *ZoneFileStore) ResolveDomainRRSet(domain string, domainType shared.DomainType) ([]string, error) {
+	values, _, err := z.ResolveDomainRRSetTTL(domain, domainType)
+	return values, err
+}
+
+func (z *ZoneFileStore) ResolveDomainRRSetTTL(domain string, domainType shared.DomainType) ([]string, uint32, error) {
+	domain = strings.ToLower(domain)
+
+	rrset, ok := z.getRecords()[domainType][domain]
+	if !ok || len(rrset.Values) == 0 {
+		return nil, 0, shared.ErrStorageNotFound
+	}
+	return rrset.Values, rrset.Ttl, nil
+}
+
+func (z *ZoneFileStore) PutDomain(domain, resolve string, domainType shared.DomainType) {
+	panic("unsupported")
+}
+
+var _ dnscore.DnsStorage = new(ZoneFileStore)
+var _ dnscore.DnsRRSetStorage = new(ZoneFileStore)
+var _ dnscore.DnsTTLStorage = new(ZoneFileStore)
@@ -0,0 +1,97 @@
+package dnszone
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+// ZoneRRSet holds the values of an rrset and the smallest ttl of its records
+type ZoneRRSet struct {
+	Ttl    uint32
+	Values []string
+}
+
+// ZoneRecords holds rrsets indexed by record type and lower case fqdn
+type ZoneRecords map[shared.DomainType]map[string]*ZoneRRSet
+
+func (z ZoneRecords) add(domainType shared.DomainType, domain, value string, ttl uint32) {
+	sub, ok := z[domainType]
+	if !ok {
+		sub = make(map[string]*ZoneRRSet)
+		z[domainType] = sub
+	}
+	rrset, ok := sub[domain]
+	if !ok {
+		rrset = &ZoneRRSet{Ttl: ttl}
+		sub[domain] = rrset
+	}
+	rrset.Ttl = min(rrset.Ttl, ttl)
+	if slices.Contains(rrset.Values, value) {
+		return
+	}
+	rrset.Values = append(rrset.Values, value)
+}
+
+// ParseZoneFile parses an RFC 1035 master file.
+// The returned error carries the file name and the line number of the failure.
+func ParseZoneFile(file string) (ZoneRecords, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+	return ParseZone(f, file)
+}
+
+// ParseZone parses master file content from the reader.
+// $ORIGIN and $TTL directives are supported. Relative names without $ORIGIN are relative to the root.
+// Records without ttl fall back to $TTL, and an rrset is served with the smallest ttl of its records.
+// Record types which are not served by the dns module are skipped with a warning.
+func ParseZone(r io.Reader, file string) (ZoneRecords, error) {
+	records := ZoneRecords{}
+
+	zp := dns.NewZoneParser(r, ".", file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		domain := strings.ToLower(rr.Header().Name)
+		ttl := rr.Header().Ttl
+		switch v := rr.(type) {
+		case *dns.A:
+			records.add(shared.DomainTypeA, domain, v.A.String(), ttl)
+		case *dns.AAAA:
+			records.add(shared.DomainTypeAAAA, domain, v.AAAA.String(), ttl)
+		case *dns.TXT:
+			records.add(shared.DomainTypeTxt, domain, strings.Join(v.Txt, ""), ttl)
+		case *dns.SRV:
+			data, err := json.Marshal(&dnscore.SrvRecordValue{
+				Priority: v.Priority,
+				Weight:   v.Weight,
+				Port:     v.Port,
+				Target:   strings.ToLower(v.Target),
+			})
+			if err != nil {
+				return nil, err
+			}
+			records.add(shared.DomainTypeSrv, domain, string(data), ttl)
+		case *dns.PTR:
+			records.add(shared.DomainTypePtr, domain, dns.Fqdn(strings.ToLower(v.Ptr)), ttl)
+		case *dns.SOA, *dns.NS:
+			// zone apex records are accepted but not served
+		default:
+			logger.Warn("unsupported record type in zone file:", file, "domain:", domain, "type:", dns.TypeToString[rr.Header().Rrtype])
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,97 @@
+package dnszone
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/dnscore"
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const testZone = `$ORIGIN example.dns.
+$TTL 300
+@       IN SOA ns1 admin 1 7200 3600 1209600 300
+node1   IN A    127.0.0.1
+node1   IN A    127.0.0.2
+Node1   IN AAAA ::1
+node1   IN TXT  "Hello" " World"
+_svc._tcp IN SRV 10 20 30 service.node1
+1.0.0.127.in-addr.arpa. IN PTR node1
+alias   IN CNAME node1
+`
+
+func TestParseZone(t *testing.T) {
+	records, err := ParseZone(strings.NewReader(testZone), "test.zone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := records[shared.DomainTypeA]["node1.example.dns."].Values; len(a) != 2 || a[0] != "127.0.0.1" || a[1] != "127.0.0.2" {
+		t.Fatal("A rrset not match:", a)
+	}
+	if aaaa := records[shared.DomainTypeAAAA]["node1.example.dns."].Values; len(aaaa) != 1 || aaaa[0] != "::1" {
+		t.Fatal("AAAA rrset not match:", aaaa)
+	}
+	if txt := records[shared.DomainTypeTxt]["node1.example.dns."].Values; len(txt) != 1 || txt[0] != "Hello World" {
+		t.Fatal("TXT rrset not match:", txt)
+	}
+	if srv := records[shared.DomainTypeSrv]["_svc._tcp.example.dns."].Values; len(srv) != 1 || srv[0] != `{"priority":10,"weight":20,"port":30,"target":"service.node1.example.dns."}` {
+		t.Fatal("SRV rrset not match:", srv)
+	}
+	if ptr := records[shared.DomainTypePtr]["1.0.0.127.in-addr.arpa."].Values; len(ptr) != 1 || ptr[0] != "node1.example.dns." {
+		t.Fatal("PTR rrset not match:", ptr)
+	}
+	if ttl := records[shared.DomainTypeA]["node1.example.dns."].Ttl; ttl != 300 {
+		t.Fatal("ttl of $TTL not match:", ttl)
+	}
+}
+
+func TestParseZoneError(t *testing.T) {
+	_, err := ParseZone(strings.NewReader("$ORIGIN example.dns.\nnode1 IN A 127.0.0.1\nnode2 IN A 999.0.0.1\n"), "bad.zone")
+	if err == nil {
+		t.Fatal("parse error expected")
+	}
+	if !strings.Contains(err.Error(), "bad.zone") || !strings.Contains(err.Error(), "line: 3") {
+		t.Fatal("error should contain file name and line number:", err)
+	}
+}
+
+func TestZoneFileStoreTTL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttl.zone")
+	zone := "$ORIGIN example.dns.\n$TTL 60\nnode1 IN A 127.0.0.1\nnode2 30 IN A 127.0.0.2\nnode2 IN A 127.0.0.3\n"
+	if err := os.WriteFile(file, []byte(zone), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewZoneFileStore([]string{file})
+	if err := store.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Stop()
+	}()
+
+	handler := dnscore.NewRecordAHandler(nil, store, false)
+	for domain, expected := range map[string]uint32{
+		"node1.example.dns.": 60,
+		// the smallest ttl of the rrset
+		"node2.example.dns.": 30,
+	} {
+		m := new(dns.Msg)
+		m.SetQuestion(domain, dns.TypeA)
+		reply, err := handler.HandleQuestion(m, dnscore.NewRequestContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply.Answer) == 0 {
+			t.Fatal("no answer:", domain)
+		}
+		for _, rr := range reply.Answer {
+			if rr.Header().Ttl != expected {
+				t.Fatal("unexpected ttl of", domain, ":", rr.Header().Ttl)
+			}
+		}
+	}
+}
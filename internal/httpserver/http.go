@@ -48,6 +48,11 @@ func (h *HttpServer) Add(method HttpMethod, path string, handler iface.HttpHandl
 	h.router.Handle(newHandle(method, path, handler, h.GeneralErrorHandler))
 }
 
+// ServeHTTP serves the request by the added handlers without listening, e.g. in tests
+func (h *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.router.ServeHTTP(w, r)
+}
+
 func (h *HttpServer) Startup() error {
 	ln, err := net.Listen("tcp", h.listenAddr)
 	if err != nil {
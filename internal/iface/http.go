@@ -9,6 +9,19 @@ import (
 
 type HttpHandler func(request *http.Request, params httprouter.Params) (HttpResult, error)
 
+// Roles of api tokens. RoleAdmin grants all the roles.
+const (
+	RoleDiscovery = "discovery"
+	RoleRegister  = "register"
+	RoleAdmin     = "admin"
+	RoleKvRead    = "kv-read"
+	RoleKvWrite   = "kv-write"
+)
+
+// HttpAuthorizer checks the credential of the request for the role in the namespace of the request.
+// It returns the namespace owned by the credential, or the result rejecting the request.
+type HttpAuthorizer func(request *http.Request, role string) (string, HttpResult)
+
 type HttpResultRender func(w http.ResponseWriter) error
 
 type HttpResult interface {
@@ -16,6 +16,8 @@ type HttpServiceContainer struct {
 	h *httpserver.HttpServer
 
 	kvstor iface.KVStorage
+
+	authorizer iface.HttpAuthorizer
 }
 
 func NewHttpServiceContainer(h *httpserver.HttpServer) *HttpServiceContainer {
@@ -24,10 +26,28 @@ func NewHttpServiceContainer(h *httpserver.HttpServer) *HttpServiceContainer {
 	}
 }
 
+// SetAuthorizer checks the roles of the requests, e.g. kv-read and kv-write, and resolves their namespaces.
+// All requests are allowed in the default namespace without authorizer.
+func (h *HttpServiceContainer) SetAuthorizer(authorizer iface.HttpAuthorizer) *HttpServiceContainer {
+	h.authorizer = authorizer
+	return h
+}
+
+func (h *HttpServiceContainer) authorize(request *http.Request, role string) (string, iface.HttpResult) {
+	if h.authorizer == nil {
+		return "", nil
+	}
+	return h.authorizer(request, role)
+}
+
 func (h *HttpServiceContainer) SetupKVStorage(stor iface.KVStorage) *HttpServiceContainer {
 	h.kvstor = stor
 	h.h.Add(httpserver.MethodGet, "/services/kvstore/:key", func(request *http.Request, params httprouter.Params) (iface.HttpResult, error) {
-		key := strings.TrimSpace(params.ByName("key"))
+		namespace, r := h.authorize(request, iface.RoleKvRead)
+		if r != nil {
+			return r, nil
+		}
+		key := kvKey(namespace, params)
 		if key == "" {
 			return iface.StatusOnlyResult(http.StatusBadRequest), nil
 		}
@@ -42,7 +62,11 @@ func (h *HttpServiceContainer) SetupKVStorage(stor iface.KVStorage) *HttpService
 		return iface.RawResult(val), nil
 	})
 	h.h.Add(httpserver.MethodPut, "/services/kvstore/:key", func(request *http.Request, params httprouter.Params) (iface.HttpResult, error) {
-		key := strings.TrimSpace(params.ByName("key"))
+		namespace, r := h.authorize(request, iface.RoleKvWrite)
+		if r != nil {
+			return r, nil
+		}
+		key := kvKey(namespace, params)
 		if key == "" {
 			return iface.StatusOnlyResult(http.StatusBadRequest), nil
 		}
@@ -59,6 +83,19 @@ func (h *HttpServiceContainer) SetupKVStorage(stor iface.KVStorage) *HttpService
 	return h
 }
 
+// kvKey returns the stored key. Keys of the namespace authorized for the request are prefixed with the namespace.
+func kvKey(namespace string, params httprouter.Params) string {
+	key := strings.TrimSpace(params.ByName("key"))
+	// "/" separates the namespace from the key
+	if key == "" || strings.Contains(key, "/") {
+		return ""
+	}
+	if namespace != "" {
+		return namespace + "/" + key
+	}
+	return key
+}
+
 func (h *HttpServiceContainer) Startup() error {
 	return h.h.Startup()
 }
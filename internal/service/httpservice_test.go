@@ -0,0 +1,26 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestKvKey(t *testing.T) {
+	for _, v := range []struct {
+		namespace string
+		key       string
+		expected  string
+	}{
+		{"", "k", "k"},
+		{"team1", " k ", "team1/k"},
+		{"team1", "", ""},
+		// "/" would let keys of the default namespace collide with keys of namespaces
+		{"", "team1/k", ""},
+		{"team1", "a/b", ""},
+	} {
+		if key := kvKey(v.namespace, httprouter.Params{{Key: "key", Value: v.key}}); key != v.expected {
+			t.Fatal("unexpected key:", key, v)
+		}
+	}
+}
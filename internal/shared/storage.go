@@ -1,6 +1,9 @@
 package shared
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 type DomainType int
 
@@ -12,4 +15,30 @@ const (
 	DomainTypeAAAA
 )
 
+var domainTypeNames = map[DomainType]string{
+	DomainTypeA:    "A",
+	DomainTypeTxt:  "TXT",
+	DomainTypeSrv:  "SRV",
+	DomainTypePtr:  "PTR",
+	DomainTypeAAAA: "AAAA",
+}
+
+func (t DomainType) String() string {
+	if name, ok := domainTypeNames[t]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseDomainType converts the record type name to DomainType case-insensitively
+func ParseDomainType(name string) (DomainType, bool) {
+	name = strings.ToUpper(name)
+	for t, v := range domainTypeNames {
+		if v == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
 var ErrStorageNotFound = errors.New("not found")
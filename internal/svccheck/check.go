@@ -0,0 +1,141 @@
+package svccheck
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	TypeTcp  = "tcp"
+	TypeHttp = "http"
+	TypeGrpc = "grpc"
+	TypeResp = "resp"
+
+	DefaultTimeout = 3 * time.Second
+)
+
+// Check probes a service instance
+type Check struct {
+	// Type is one of tcp, http, grpc and resp
+	Type string
+	// Address is host:port of the instance
+	Address string
+	// Path of http checks. The instance is healthy when the status code is less than 400.
+	Path string
+	// Service of grpc health checks. Empty checks the overall health of the server.
+	Service string
+	Timeout time.Duration
+}
+
+func (c *Check) Validate() error {
+	switch c.Type {
+	case TypeTcp, TypeHttp, TypeGrpc, TypeResp:
+	default:
+		return errors.New("unknown check type:" + c.Type)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid check address:%s %w", c.Address, err)
+	}
+	if c.Timeout < 0 {
+		return errors.New("negative check timeout")
+	}
+	return nil
+}
+
+// Run probes the instance once. nil is returned when the instance is healthy.
+func (c *Check) Run(ctx context.Context) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch c.Type {
+	case TypeTcp:
+		conn, err := new(net.Dialer).DialContext(ctx, "tcp", c.Address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case TypeHttp:
+		return c.runHttp(ctx)
+	case TypeGrpc:
+		return c.runGrpc(ctx)
+	case TypeResp:
+		return c.runResp(ctx)
+	default:
+		return errors.New("unknown check type:" + c.Type)
+	}
+}
+
+func (c *Check) runHttp(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.Address+"/"+strings.TrimPrefix(c.Path, "/"), nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("http status code:%d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runGrpc invokes the standard grpc.health.v1.Health/Check
+func (c *Check) runGrpc(ctx context.Context) error {
+	conn, err := grpc.NewClient(c.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.Service})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return errors.New("grpc health status:" + resp.GetStatus().String())
+	}
+	return nil
+}
+
+// runResp sends PING to redis compatible servers and expects PONG
+func (c *Check) runResp(ctx context.Context) error {
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line = strings.TrimSpace(line); line != "+PONG" {
+		return errors.New("unexpected PING reply:" + line)
+	}
+	return nil
+}
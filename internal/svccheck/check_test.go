@@ -0,0 +1,114 @@
+package svccheck
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestCheckTcpAndHttp(t *testing.T) {
+	l := listen(t)
+	if err := (&Check{Type: TypeTcp, Address: l.Addr().String()}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Check{Type: TypeTcp, Address: closedAddress(t)}).Run(context.Background()); err == nil {
+		t.Fatal("closed port should fail")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+	if err := (&Check{Type: TypeHttp, Address: addr, Path: "/healthz"}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Check{Type: TypeHttp, Address: addr, Path: "/other"}).Run(context.Background()); err == nil {
+		t.Fatal("status code 503 should fail")
+	}
+}
+
+func TestCheckResp(t *testing.T) {
+	l := listen(t)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// *1, $4, PING
+				for i := 0; i < 3; i++ {
+					if _, err := r.ReadString('\n'); err != nil {
+						return
+					}
+				}
+				_, _ = conn.Write([]byte("+PONG\r\n"))
+			}()
+		}
+	}()
+	if err := (&Check{Type: TypeResp, Address: l.Addr().String()}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckGrpc(t *testing.T) {
+	l := listen(t)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go func() { _ = server.Serve(l) }()
+	defer server.Stop()
+
+	check := &Check{Type: TypeGrpc, Address: l.Addr().String(), Service: "svc"}
+	healthServer.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	if err := check.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	healthServer.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := check.Run(context.Background()); err == nil {
+		t.Fatal("not serving should fail")
+	}
+}
+
+func TestCheckValidate(t *testing.T) {
+	for _, c := range []*Check{
+		{Type: "icmp", Address: "127.0.0.1:80"},
+		{Type: TypeTcp, Address: "127.0.0.1"},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Fatal("invalid check should fail:", c)
+		}
+	}
+}
@@ -14,3 +14,9 @@ func init() {
 	}
 	Manager = manager
 }
+
+// NewFileManager creates a manager writing to rotated files only, without caller info and console output.
+// It is used by dedicated logs, e.g. dns query log.
+func NewFileManager(filePathPrefix string, maxDays, maxFileSize, maxFilePerDay int) (logging.LoggerManager, error) {
+	return logging.NewLoggerManager(filePathPrefix, maxDays, maxFileSize, maxFilePerDay, logrus.InfoLevel, false, false)
+}
@@ -0,0 +1,269 @@
+package bootstrap
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+const (
+	// DefaultDomainTombstoneTTL must be longer than the max replication lag within the cluster.
+	// Otherwise a peer which has not received the deletion yet may bring the record back.
+	DefaultDomainTombstoneTTL = 24 * time.Hour
+
+	domainTombstoneGCInterval = time.Minute
+)
+
+// memDomainRecord is a dns record put at runtime and replicated within the cluster.
+// Conflicts of the same name and type are resolved by last writer wins:
+// the record with greater Version wins and Origin breaks the tie.
+// Deletion is kept as a tombstone so that it can override the records from other nodes,
+// and the tombstone is dropped after DomainTombstoneTTL.
+type memDomainRecord struct {
+	Value   string `json:"value"`
+	Origin  string `json:"origin"`
+	Version int64  `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func (r *memDomainRecord) newerThan(other *memDomainRecord) bool {
+	if other == nil {
+		return true
+	}
+	if r.Version != other.Version {
+		return r.Version > other.Version
+	}
+	return r.Origin > other.Origin
+}
+
+func (m *MemStore) PutDomain(domain, resolve string, domainType shared.DomainType) {
+	// convert domain to lower case in order to achieve builtin case in-sensitive support
+	domain = strings.ToLower(domain)
+
+	defer m.rwlock.Unlock()
+	m.rwlock.Lock()
+
+	fqdn := dns.Fqdn(domain)
+	m.putDomainRecord(domainType, fqdn, &memDomainRecord{
+		Value:   resolve,
+		Origin:  m.nodeId,
+		Version: m.nextDomainVersion(domainType, fqdn),
+	})
+}
+
+// nextDomainVersion generates a version greater than all known versions of the record
+// in order to override them even with clock skew between nodes. Lock is required.
+func (m *MemStore) nextDomainVersion(domainType shared.DomainType, fqdn string) int64 {
+	version := time.Now().UnixNano()
+	if r, ok := m.currentDomains[domainType][fqdn]; ok && r.Version >= version {
+		version = r.Version + 1
+	}
+	for _, v := range m.dataFrom {
+		if r, ok := v.DomainMapping[domainType][fqdn]; ok && r.Version >= version {
+			version = r.Version + 1
+		}
+	}
+	return version
+}
+
+// putDomainRecord stores the record of current node and notifies peers. Lock is required.
+func (m *MemStore) putDomainRecord(domainType shared.DomainType, fqdn string, record *memDomainRecord) {
+	sub, ok := m.currentDomains[domainType]
+	if !ok {
+		sub = make(map[string]*memDomainRecord)
+		m.currentDomains[domainType] = sub
+	}
+	sub[fqdn] = record
+
+	// update pending list
+	for _, v := range m.dataTo {
+		newList := make([]*dataTransferNodeData, 0, len(v.Add)+1)
+		for _, item := range v.Add {
+			if item.Domain == fqdn && item.DomainType == domainType {
+				continue
+			}
+			newList = append(newList, item)
+		}
+		v.Add = append(newList, &dataTransferNodeData{
+			DomainType: domainType,
+			Domain:     fqdn,
+			Record:     record,
+		})
+	}
+
+	m.refreshDomain(domainType, fqdn)
+}
+
+// effectiveDomainRecord returns the winner among current node and peers including tombstones. Lock is required.
+func (m *MemStore) effectiveDomainRecord(domainType shared.DomainType, fqdn string) *memDomainRecord {
+	winner := m.currentDomains[domainType][fqdn]
+	for _, v := range m.dataFrom {
+		if r, ok := v.DomainMapping[domainType][fqdn]; ok && r.newerThan(winner) {
+			winner = r
+		}
+	}
+	return winner
+}
+
+// refreshDomain recalculates the effective record of the domain. Lock is required.
+func (m *MemStore) refreshDomain(domainType shared.DomainType, fqdn string) {
+	winner := m.effectiveDomainRecord(domainType, fqdn)
+
+	sub, ok := m.staticDomainMapping[domainType]
+	if !ok {
+		sub = make(map[string]string)
+		m.staticDomainMapping[domainType] = sub
+	}
+	if winner == nil || winner.Deleted {
+		delete(sub, fqdn)
+	} else {
+		sub[fqdn] = winner.Value
+	}
+}
+
+// domainWinners collects the effective records including tombstones. Lock is required.
+func (m *MemStore) domainWinners() map[shared.DomainType]map[string]*memDomainRecord {
+	winners := make(map[shared.DomainType]map[string]*memDomainRecord)
+	merge := func(mapping map[shared.DomainType]map[string]*memDomainRecord) {
+		for domainType, sub := range mapping {
+			winnerSub, ok := winners[domainType]
+			if !ok {
+				winnerSub = make(map[string]*memDomainRecord)
+				winners[domainType] = winnerSub
+			}
+			for fqdn, r := range sub {
+				if r.newerThan(winnerSub[fqdn]) {
+					winnerSub[fqdn] = r
+				}
+			}
+		}
+	}
+	merge(m.currentDomains)
+	for _, v := range m.dataFrom {
+		merge(v.DomainMapping)
+	}
+	return winners
+}
+
+// buildDomainData recalculates all the effective records from current node and peers. Lock is required.
+func (m *MemStore) buildDomainData() {
+	winners := m.domainWinners()
+
+	newMapping := make(map[shared.DomainType]map[string]string)
+	for domainType, sub := range winners {
+		newSub := make(map[string]string)
+		for fqdn, r := range sub {
+			if !r.Deleted {
+				newSub[fqdn] = r.Value
+			}
+		}
+		newMapping[domainType] = newSub
+	}
+	m.staticDomainMapping = newMapping
+}
+
+func (m *MemStore) GetDomain(domain string, domainType shared.DomainType) (*DomainRecord, error) {
+	fqdn := dns.Fqdn(strings.ToLower(domain))
+
+	defer m.rwlock.RUnlock()
+	m.rwlock.RLock()
+
+	r := m.effectiveDomainRecord(domainType, fqdn)
+	if r == nil || r.Deleted {
+		return nil, shared.ErrStorageNotFound
+	}
+	return r.toDomainRecord(domainType, fqdn), nil
+}
+
+func (m *MemStore) ListDomains() ([]*DomainRecord, error) {
+	defer m.rwlock.RUnlock()
+	m.rwlock.RLock()
+
+	var result []*DomainRecord
+	for domainType, sub := range m.domainWinners() {
+		for fqdn, r := range sub {
+			if !r.Deleted {
+				result = append(result, r.toDomainRecord(domainType, fqdn))
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Domain != result[j].Domain {
+			return result[i].Domain < result[j].Domain
+		}
+		return result[i].Type < result[j].Type
+	})
+	return result, nil
+}
+
+func (m *MemStore) DeleteDomain(domain string, domainType shared.DomainType) error {
+	fqdn := dns.Fqdn(strings.ToLower(domain))
+
+	defer m.rwlock.Unlock()
+	m.rwlock.Lock()
+
+	if r := m.effectiveDomainRecord(domainType, fqdn); r == nil || r.Deleted {
+		return shared.ErrStorageNotFound
+	}
+	// the tombstone is replicated in order to remove the record put by other nodes
+	m.putDomainRecord(domainType, fqdn, &memDomainRecord{
+		Origin:  m.nodeId,
+		Version: m.nextDomainVersion(domainType, fqdn),
+		Deleted: true,
+	})
+	return nil
+}
+
+// RunDomainTombstoneGC drops expired tombstones periodically
+func (m *MemStore) RunDomainTombstoneGC() {
+	for {
+		time.Sleep(domainTombstoneGCInterval)
+		if count := m.gcDomainTombstones(time.Now()); count > 0 {
+			logger.Info("dropped expired dns record tombstones:", count)
+		}
+	}
+}
+
+// gcDomainTombstones drops the tombstones older than DomainTombstoneTTL together with the records they override,
+// including the records of current node and peers and the pending changes to peers.
+// Every node drops them after the same window, so the overridden records are not brought back by peers.
+func (m *MemStore) gcDomainTombstones(now time.Time) int {
+	defer m.rwlock.Unlock()
+	m.rwlock.Lock()
+
+	deadline := now.Add(-m.DomainTombstoneTTL).UnixNano()
+	count := 0
+	for domainType, sub := range m.domainWinners() {
+		for fqdn, r := range sub {
+			if !r.Deleted || r.Version > deadline {
+				continue
+			}
+			delete(m.currentDomains[domainType], fqdn)
+			for _, v := range m.dataFrom {
+				delete(v.DomainMapping[domainType], fqdn)
+			}
+			for _, v := range m.dataTo {
+				v.Add = slices.DeleteFunc(v.Add, func(item *dataTransferNodeData) bool {
+					return item.Domain == fqdn && item.DomainType == domainType
+				})
+			}
+			count++
+		}
+	}
+	return count
+}
+
+func (r *memDomainRecord) toDomainRecord(domainType shared.DomainType, fqdn string) *DomainRecord {
+	return &DomainRecord{
+		Domain:  fqdn,
+		Type:    domainType.String(),
+		Value:   r.Value,
+		Origin:  r.Origin,
+		Version: r.Version,
+	}
+}
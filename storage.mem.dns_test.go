@@ -0,0 +1,217 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
+)
+
+func replicate(t *testing.T, from, to *MemStore, toNode string) {
+	add, del, err := from.FetchChangesForPeerNodeRequest(toNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := to.SyncFrom(from.nodeId, from.nodeId, add, del); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemStoreDomainReplication(t *testing.T) {
+	node1 := NewMemStore("node1", nil)
+	node2 := NewMemStore("node2", nil)
+
+	node1.PutDomain("Record1.example.dns", "127.0.0.1", shared.DomainTypeA)
+
+	// full
+	full, err := node1.FetchFullAndWatch("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.FullFrom("node1", full); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); err != nil || val != "127.0.0.1" {
+		t.Fatal("full replication failed:", val, err)
+	}
+
+	// incremental
+	node1.PutDomain("record2.example.dns", "127.0.0.2", shared.DomainTypeA)
+	replicate(t, node1, node2, "node2")
+	if val, err := node2.ResolveDomain("record2.example.dns.", shared.DomainTypeA); err != nil || val != "127.0.0.2" {
+		t.Fatal("incremental replication failed:", val, err)
+	}
+
+	// the latest write wins
+	node2.PutDomain("record1.example.dns", "127.0.0.3", shared.DomainTypeA)
+	if val, _ := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); val != "127.0.0.3" {
+		t.Fatal("latest record should win:", val)
+	}
+	node1.PutDomain("record1.example.dns", "127.0.0.4", shared.DomainTypeA)
+	replicate(t, node1, node2, "node2")
+	if val, _ := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); val != "127.0.0.4" {
+		t.Fatal("latest record should win:", val)
+	}
+
+	// peer leaves
+	if err := node2.Abandon("node1"); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); val != "127.0.0.3" {
+		t.Fatal("local record should be restored:", val)
+	}
+	if _, err := node2.ResolveDomain("record2.example.dns.", shared.DomainTypeA); err == nil {
+		t.Fatal("record of abandoned node should be removed")
+	}
+}
+
+func TestMemStoreDomainReplicationWithPreviousVersion(t *testing.T) {
+	node1 := NewMemStore("node1", nil)
+	node2 := NewMemStore("node2", nil)
+	if _, err := node1.FetchFullAndWatch("node2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.FullFrom("node1", map[string][]byte{_DefaultDataKey: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := node1.PublishService("svc", &ServiceItem{Addr: "10.0.0.1:80", NodeId: "n1"}); err != nil {
+		t.Fatal(err)
+	}
+	node1.PutDomain("record1.example.dns", "127.0.0.1", shared.DomainTypeA)
+	add, del, err := node1.FetchChangesForPeerNodeRequest("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// peers of previous versions decode the changes of _DefaultDataKey as services only
+	var services []struct {
+		ServiceName string
+		NodeName    string
+		Info        serviceInstance
+	}
+	if err := json.Unmarshal(add[_DefaultDataKey], &services); err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 || services[0].ServiceName != "svc" || services[0].NodeName != "n1" {
+		t.Fatal("dns records should not be sent as services:", services)
+	}
+
+	// changes from peers of previous versions have no _DomainDataKey
+	if err := node2.SyncFrom("node1", "node1", map[string][]byte{_DefaultDataKey: add[_DefaultDataKey]}, del); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := node2.GetServiceList("svc"); len(items) != 1 {
+		t.Fatal("service should be replicated:", items)
+	}
+	if err := node2.SyncFrom("node1", "node1", add, del); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); err != nil || val != "127.0.0.1" {
+		t.Fatal("dns record should be replicated:", val, err)
+	}
+	if _, ok := node2.services[""]; ok {
+		t.Fatal("dns record should not be stored as service")
+	}
+}
+
+func TestMemStoreDomainDeletion(t *testing.T) {
+	node1 := NewMemStore("node1", nil)
+	node2 := NewMemStore("node2", nil)
+	full, err := node1.FetchFullAndWatch("node2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.FullFrom("node1", full); err != nil {
+		t.Fatal(err)
+	}
+	full, err = node2.FetchFullAndWatch("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node1.FullFrom("node2", full); err != nil {
+		t.Fatal(err)
+	}
+
+	node1.PutDomain("record1.example.dns", "127.0.0.1", shared.DomainTypeA)
+	replicate(t, node1, node2, "node2")
+
+	// delete the record put by another node
+	if err := node2.DeleteDomain("record1.example.dns", shared.DomainTypeA); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.DeleteDomain("record1.example.dns", shared.DomainTypeA); err != shared.ErrStorageNotFound {
+		t.Fatal("deleted record should not be found:", err)
+	}
+	replicate(t, node2, node1, "node1")
+	if _, err := node1.GetDomain("record1.example.dns", shared.DomainTypeA); err != shared.ErrStorageNotFound {
+		t.Fatal("tombstone should override the record:", err)
+	}
+	if list, _ := node1.ListDomains(); len(list) != 0 {
+		t.Fatal("tombstone should not be listed:", list)
+	}
+
+	node1.PutDomain("record1.example.dns", "127.0.0.2", shared.DomainTypeA)
+	r, err := node1.GetDomain("RECORD1.example.dns.", shared.DomainTypeA)
+	if err != nil || r.Value != "127.0.0.2" || r.Origin != "node1" || r.Type != "A" {
+		t.Fatal("record should be put again:", r, err)
+	}
+}
+
+func TestMemStoreDomainTombstoneGC(t *testing.T) {
+	node1 := NewMemStore("node1", nil)
+	node2 := NewMemStore("node2", nil)
+	for _, v := range []struct {
+		from, to *MemStore
+	}{{node1, node2}, {node2, node1}} {
+		full, err := v.from.FetchFullAndWatch(v.to.nodeId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := v.to.FullFrom(v.from.nodeId, full); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	node1.PutDomain("record1.example.dns", "127.0.0.1", shared.DomainTypeA)
+	replicate(t, node1, node2, "node2")
+	if err := node2.DeleteDomain("record1.example.dns", shared.DomainTypeA); err != nil {
+		t.Fatal(err)
+	}
+	replicate(t, node2, node1, "node1")
+
+	// tombstones within ttl are kept
+	if count := node1.gcDomainTombstones(time.Now()); count != 0 {
+		t.Fatal("tombstone should be kept within ttl:", count)
+	}
+	after := time.Now().Add(node1.DomainTombstoneTTL + time.Second)
+	if count := node1.gcDomainTombstones(after); count != 1 {
+		t.Fatal("expired tombstone should be dropped:", count)
+	}
+	if count := node2.gcDomainTombstones(after); count != 1 {
+		t.Fatal("expired tombstone should be dropped:", count)
+	}
+	for _, node := range []*MemStore{node1, node2} {
+		if _, err := node.GetDomain("record1.example.dns", shared.DomainTypeA); err != shared.ErrStorageNotFound {
+			t.Fatal("overridden record should not be brought back:", node.nodeId, err)
+		}
+	}
+
+	// the overridden record is not replicated again after the tombstone is dropped
+	full, err := node1.FetchFullAndWatch("node3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node3 := NewMemStore("node3", nil)
+	if err := node3.FullFrom("node1", full); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := node3.ResolveDomain("record1.example.dns.", shared.DomainTypeA); err != shared.ErrStorageNotFound {
+		t.Fatal("overridden record should not be replicated:", err)
+	}
+	replicate(t, node1, node2, "node2")
+	if _, err := node2.ResolveDomain("record1.example.dns.", shared.DomainTypeA); err != shared.ErrStorageNotFound {
+		t.Fatal("overridden record should not be replicated:", err)
+	}
+}
@@ -3,8 +3,10 @@ package bootstrap
 import (
 	"encoding/json"
 	"errors"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 
@@ -15,20 +17,32 @@ import (
 
 const (
 	_DefaultDataKey = "__default__"
+	// _DomainDataKey carries dns record changes apart from the service changes of _DefaultDataKey,
+	// so that peers of previous versions, which only read _DefaultDataKey, ignore them
+	_DomainDataKey = "__domain__"
 )
 
 var logger = logging.Manager.GetLogger("storage")
 
 type MemStore struct {
+	nodeId string
+
+	// records put at runtime, merged from current node and peers
 	staticDomainMapping map[shared.DomainType]map[string]string
-	services            map[string]map[string]struct {
-		Addr string
-	}
+	// static rules from configuration file, replaced as a whole on configuration reload
+	configDomainMapping map[shared.DomainType]map[string]string
+	services            map[string]map[string]serviceInstance
+	// modification index per service with instances, increased whenever the instance list of the service changes
+	serviceIndexes map[string]uint64
+	serviceIndex   uint64
+	// index of the latest change that removed the last instance of a service
+	serviceRemovedIndex uint64
+	// watchers blocked per service
+	serviceWatches map[string]*serviceWatch
 
 	// current node data
-	currentServices map[string]map[string]struct {
-		Addr string
-	}
+	currentServices map[string]map[string]serviceInstance
+	currentDomains  map[shared.DomainType]map[string]*memDomainRecord
 	// received from remote
 	dataFrom map[string]*memNodeData
 	// pending sending data to remote
@@ -40,26 +54,30 @@ type MemStore struct {
 	rwlock sync.RWMutex
 
 	dnsStores []dnscore.DnsStorage
+
+	// DomainTombstoneTTL is how long the tombstones of deleted dns records are kept
+	DomainTombstoneTTL time.Duration
 }
 
 func (this *MemStore) GetServiceList(service string) ([]*ServiceItem, error) {
 	this.rwlock.RLock()
 	defer this.rwlock.RUnlock()
 
+	return this.getServiceList(service), nil
+}
+
+func (this *MemStore) getServiceList(service string) []*ServiceItem {
 	li, ok := this.services[service]
 	if !ok {
-		return nil, nil
+		return nil
 	}
 
 	var r []*ServiceItem
 	for k, v := range li {
-		r = append(r, &ServiceItem{
-			Addr:   v.Addr,
-			NodeId: k,
-		})
+		r = append(r, v.item(k))
 	}
 
-	return r, nil
+	return r
 }
 
 func (this *MemStore) PublishService(service string, item *ServiceItem) error {
@@ -70,19 +88,24 @@ func (this *MemStore) PublishService(service string, item *ServiceItem) error {
 	{
 		p, ok := this.services[service]
 		if !ok {
-			p = map[string]struct{ Addr string }{}
+			p = map[string]serviceInstance{}
 			this.services[service] = p
 		}
-		p[item.NodeId] = struct{ Addr string }{Addr: item.Addr}
+		prev, existed := p[item.NodeId]
+		p[item.NodeId] = item.instance()
+		// republishing the same instance keeps watchers blocked
+		if !existed || !reflect.DeepEqual(prev, p[item.NodeId]) {
+			this.touchServices(service)
+		}
 	}
 	// update current
 	{
 		p, ok := this.currentServices[service]
 		if !ok {
-			p = map[string]struct{ Addr string }{}
+			p = map[string]serviceInstance{}
 			this.currentServices[service] = p
 		}
-		p[item.NodeId] = struct{ Addr string }{Addr: item.Addr}
+		p[item.NodeId] = item.instance()
 	}
 	// update pending list
 	{
@@ -91,9 +114,7 @@ func (this *MemStore) PublishService(service string, item *ServiceItem) error {
 			v.Add = append(v.Add, &dataTransferNodeData{
 				ServiceName: service,
 				NodeName:    item.NodeId,
-				Info: struct {
-					Addr string
-				}{Addr: item.Addr},
+				Info:        item.instance(),
 			})
 		}
 	}
@@ -120,7 +141,10 @@ func (this *MemStore) DeleteService(service string, item *ServiceItem) error {
 	{
 		p, ok := this.services[service]
 		if ok {
-			delete(p, item.NodeId)
+			if _, existed := p[item.NodeId]; existed {
+				delete(p, item.NodeId)
+				this.touchServices(service)
+			}
 			if len(p) == 0 {
 				delete(this.services, service)
 			}
@@ -142,9 +166,7 @@ func (this *MemStore) DeleteService(service string, item *ServiceItem) error {
 			v.Del = append(v.Del, &dataTransferNodeData{
 				ServiceName: service,
 				NodeName:    item.NodeId,
-				Info: struct {
-					Addr string
-				}{Addr: item.Addr},
+				Info:        item.instance(),
 			})
 			v.Add = rebuildServiceItemList(v.Add, service, item)
 		}
@@ -154,29 +176,37 @@ func (this *MemStore) DeleteService(service string, item *ServiceItem) error {
 }
 
 func (this *MemStore) buildMemData() {
-	ch := make(chan map[string]map[string]struct {
-		Addr string
-	}, 1)
+	ch := make(chan map[string]map[string]serviceInstance, 1)
 	go func() {
-		newServiceMap := make(map[string]map[string]struct {
-			Addr string
-		})
+		newServiceMap := make(map[string]map[string]serviceInstance)
 		copyMap(this.currentServices, newServiceMap)
 		for _, v := range this.dataFrom {
 			copyMap(v.ServiceMapping, newServiceMap)
 		}
 		ch <- newServiceMap
 	}()
-	this.services = <-ch
+	newServices := <-ch
+	var changed []string
+	for name, instances := range newServices {
+		if !reflect.DeepEqual(this.services[name], instances) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range this.services {
+		if _, ok := newServices[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	this.services = newServices
+	this.touchServices(changed...)
+	this.buildDomainData()
 }
 
-func copyMap(src, dst map[string]map[string]struct {
-	Addr string
-}) {
+func copyMap(src, dst map[string]map[string]serviceInstance) {
 	for k, v := range src {
 		sub, ok := dst[k]
 		if !ok {
-			sub = map[string]struct{ Addr string }{}
+			sub = map[string]serviceInstance{}
 			dst[k] = sub
 		}
 		for i, j := range v {
@@ -186,22 +216,31 @@ func copyMap(src, dst map[string]map[string]struct {
 }
 
 type memNodeData struct {
-	ServiceMapping map[string]map[string]struct {
-		Addr string
-	}
+	ServiceMapping map[string]map[string]serviceInstance
+	DomainMapping  map[shared.DomainType]map[string]*memDomainRecord
 }
 
+// dataTransferNodeData is either a service change or a dns record change when Domain is not empty.
+// Dns record changes are transferred under _DomainDataKey only.
 type dataTransferNodeData struct {
 	ServiceName string
 	NodeName    string
-	Info        struct {
-		Addr string
-	}
+	Info        serviceInstance
+
+	DomainType shared.DomainType `json:",omitempty"`
+	Domain     string            `json:",omitempty"`
+	Record     *memDomainRecord  `json:",omitempty"`
 }
 
 func memNodeDataUnmarshall(data []byte) (*memNodeData, error) {
 	m := new(memNodeData)
 	err := json.Unmarshal(data, m)
+	if m.ServiceMapping == nil {
+		m.ServiceMapping = make(map[string]map[string]serviceInstance)
+	}
+	if m.DomainMapping == nil {
+		m.DomainMapping = make(map[shared.DomainType]map[string]*memDomainRecord)
+	}
 	return m, err
 }
 
@@ -246,6 +285,14 @@ func (m *MemStore) SyncFrom(node, origNode string, add, del map[string][]byte) e
 	if err != nil {
 		return err
 	}
+	// absent when the peer is of a previous version
+	var domainData []*dataTransferNodeData
+	if d, ok := add[_DomainDataKey]; ok {
+		domainData, err = dataTransferNodeDataList(d)
+		if err != nil {
+			return err
+		}
+	}
 
 	m.rwlock.Lock()
 	defer m.rwlock.Unlock()
@@ -257,6 +304,7 @@ func (m *MemStore) SyncFrom(node, origNode string, add, del map[string][]byte) e
 
 	// delete
 	{
+		// dns record deletion is transferred as tombstone in the add list
 		for _, v := range ddata {
 			serviceMap, ok := mapping.ServiceMapping[v.ServiceName]
 			if !ok {
@@ -273,14 +321,26 @@ func (m *MemStore) SyncFrom(node, origNode string, add, del map[string][]byte) e
 		for _, v := range adata {
 			serviceMap, ok := mapping.ServiceMapping[v.ServiceName]
 			if !ok {
-				serviceMap = make(map[string]struct {
-					Addr string
-				})
+				serviceMap = make(map[string]serviceInstance)
 				mapping.ServiceMapping[v.ServiceName] = serviceMap
 			}
 			serviceMap[v.NodeName] = v.Info
 		}
 	}
+	// dns records
+	{
+		for _, v := range domainData {
+			if v.Domain == "" || v.Record == nil {
+				continue
+			}
+			domainMap, ok := mapping.DomainMapping[v.DomainType]
+			if !ok {
+				domainMap = make(map[string]*memDomainRecord)
+				mapping.DomainMapping[v.DomainType] = domainMap
+			}
+			domainMap[v.Domain] = v.Record
+		}
+	}
 
 	m.buildMemData()
 	return nil
@@ -300,11 +360,13 @@ func (m *MemStore) Abandon(node string) error {
 
 func (m *MemStore) FetchFullAndWatch(node string) (map[string][]byte, error) {
 	md := new(memNodeData)
-	md.ServiceMapping = m.currentServices
 
 	m.rwlock.Lock()
 	defer m.rwlock.Unlock()
 
+	md.ServiceMapping = m.currentServices
+	md.DomainMapping = m.currentDomains
+
 	// add watch
 	_, ok := m.dataTo[node]
 	if !ok {
@@ -329,7 +391,19 @@ func (m *MemStore) FetchChangesForPeerNodeRequest(node string) (add, del map[str
 		return nil, nil, errors.New("node not registered")
 	}
 
-	ad, err := json.Marshal(s.Add)
+	var services, domains []*dataTransferNodeData
+	for _, v := range s.Add {
+		if v.Domain != "" {
+			domains = append(domains, v)
+		} else {
+			services = append(services, v)
+		}
+	}
+	ad, err := json.Marshal(services)
+	if err != nil {
+		return nil, nil, errors.New("marshal failed")
+	}
+	dod, err := json.Marshal(domains)
 	if err != nil {
 		return nil, nil, errors.New("marshal failed")
 	}
@@ -341,6 +415,7 @@ func (m *MemStore) FetchChangesForPeerNodeRequest(node string) (add, del map[str
 	s.Del = nil
 	return map[string][]byte{
 			_DefaultDataKey: ad,
+			_DomainDataKey:  dod,
 		}, map[string][]byte{
 			_DefaultDataKey: dd,
 		}, err
@@ -355,41 +430,42 @@ func (m *MemStore) Unwatch(node string) error {
 	return nil
 }
 
-func (m *MemStore) PutDomain(domain, resolve string, domainType shared.DomainType) {
-	// convert domain to lower case in order to achieve builtin case in-sensitive support
-	domain = strings.ToLower(domain)
+func (m *MemStore) resolveStaticDomain(domain string, domainType shared.DomainType) (string, error) {
+	defer m.rwlock.RUnlock()
+	m.rwlock.RLock()
+
+	// records put at runtime take precedence over static rules from configuration
+	if r, ok := m.staticDomainMapping[domainType][domain]; ok {
+		return r, nil
+	}
+	if r, ok := m.configDomainMapping[domainType][domain]; ok {
+		return r, nil
+	}
+	return "", shared.ErrStorageNotFound
+}
+
+func (m *MemStore) ReplaceStaticRules(rules map[shared.DomainType]map[string]string) {
+	mapping := make(map[shared.DomainType]map[string]string)
+	for domainType, sub := range rules {
+		newSub := make(map[string]string)
+		for domain, resolve := range sub {
+			// convert domain to lower case in order to achieve builtin case in-sensitive support
+			newSub[dns.Fqdn(strings.ToLower(domain))] = resolve
+		}
+		mapping[domainType] = newSub
+	}
 
 	defer m.rwlock.Unlock()
 	m.rwlock.Lock()
 
-	fqdn := dns.Fqdn(domain)
-	sub, ok := m.staticDomainMapping[domainType]
-	if !ok {
-		sub = make(map[string]string)
-		m.staticDomainMapping[domainType] = sub
-	}
-	sub[fqdn] = resolve
+	m.configDomainMapping = mapping
 }
 
 func (m *MemStore) ResolveDomain(domain string, domainType shared.DomainType) (string, error) {
 	// convert domain to lower case in order to achieve builtin case in-sensitive support
 	domain = strings.ToLower(domain)
 
-	f := func() (string, error) {
-		defer m.rwlock.RUnlock()
-		m.rwlock.RLock()
-
-		sub, ok := m.staticDomainMapping[domainType]
-		if !ok {
-			return "", shared.ErrStorageNotFound
-		}
-		r, ok := sub[domain]
-		if !ok {
-			return "", shared.ErrStorageNotFound
-		}
-		return r, nil
-	}
-	if val, err := f(); errors.Is(err, shared.ErrStorageNotFound) {
+	if val, err := m.resolveStaticDomain(domain, domainType); errors.Is(err, shared.ErrStorageNotFound) {
 		// nested stores
 		for _, store := range m.dnsStores {
 			if val, err := store.ResolveDomain(domain, domainType); errors.Is(err, shared.ErrStorageNotFound) {
@@ -409,22 +485,56 @@ func (m *MemStore) ResolveDomain(domain string, domainType shared.DomainType) (s
 	}
 }
 
+func (m *MemStore) ResolveDomainRRSet(domain string, domainType shared.DomainType) ([]string, error) {
+	vals, _, err := m.ResolveDomainRRSetTTL(domain, domainType)
+	return vals, err
+}
+
+// ResolveDomainRRSetTTL resolves the rrset together with the ttl given by the nested store serving it
+func (m *MemStore) ResolveDomainRRSetTTL(domain string, domainType shared.DomainType) ([]string, uint32, error) {
+	// convert domain to lower case in order to achieve builtin case in-sensitive support
+	domain = strings.ToLower(domain)
+
+	if val, err := m.resolveStaticDomain(domain, domainType); errors.Is(err, shared.ErrStorageNotFound) {
+		// nested stores
+		for _, store := range m.dnsStores {
+			if vals, ttl, err := dnscore.ResolveDomainRRSetTTL(store, domain, domainType); errors.Is(err, shared.ErrStorageNotFound) {
+				continue
+			} else if err != nil {
+				logger.Error("error occurs while invoking nested dns store:", err)
+				continue
+			} else {
+				return vals, ttl, nil
+			}
+		}
+		return nil, 0, shared.ErrStorageNotFound
+	} else if err != nil {
+		return nil, 0, err
+	} else {
+		return []string{val}, dnscore.DefaultResponseTTL, nil
+	}
+}
+
+var _ dnscore.DnsRRSetStorage = new(MemStore)
+var _ dnscore.DnsTTLStorage = new(MemStore)
 var _ Storage = new(MemStore)
 
-func NewMemStore(nested []dnscore.DnsStorage) *MemStore {
+func NewMemStore(nodeId string, nested []dnscore.DnsStorage) *MemStore {
 	store := new(MemStore)
+	store.nodeId = nodeId
 	store.staticDomainMapping = make(map[shared.DomainType]map[string]string)
-	store.services = make(map[string]map[string]struct {
-		Addr string
-	})
-	store.currentServices = make(map[string]map[string]struct {
-		Addr string
-	})
+	store.currentDomains = make(map[shared.DomainType]map[string]*memDomainRecord)
+	store.configDomainMapping = make(map[shared.DomainType]map[string]string)
+	store.services = make(map[string]map[string]serviceInstance)
+	store.currentServices = make(map[string]map[string]serviceInstance)
+	store.serviceIndexes = make(map[string]uint64)
+	store.serviceWatches = make(map[string]*serviceWatch)
 	store.dataFrom = make(map[string]*memNodeData)
 	store.dataTo = make(map[string]*struct {
 		Add []*dataTransferNodeData
 		Del []*dataTransferNodeData
 	})
 	store.dnsStores = nested
+	store.DomainTombstoneTTL = DefaultDomainTombstoneTTL
 	return store
 }
@@ -0,0 +1,81 @@
+package bootstrap
+
+import (
+	"context"
+)
+
+// serviceWatch is closed once the service changes in order to wake up its watchers
+type serviceWatch struct {
+	ch       chan struct{}
+	watchers int
+}
+
+// touchServices increases the modification index of the services and wakes up their watchers. this.rwlock must be held.
+func (this *MemStore) touchServices(services ...string) {
+	if len(services) == 0 {
+		return
+	}
+	this.serviceIndex++
+	for _, service := range services {
+		if len(this.services[service]) > 0 {
+			this.serviceIndexes[service] = this.serviceIndex
+		} else {
+			// the index of services without instances falls back to serviceRemovedIndex
+			delete(this.serviceIndexes, service)
+			this.serviceRemovedIndex = this.serviceIndex
+		}
+		if w, ok := this.serviceWatches[service]; ok {
+			close(w.ch)
+			delete(this.serviceWatches, service)
+		}
+	}
+}
+
+// serviceIndexOf returns the modification index of the service. this.rwlock must be held.
+func (this *MemStore) serviceIndexOf(service string) uint64 {
+	if index, ok := this.serviceIndexes[service]; ok {
+		return index
+	}
+	return this.serviceRemovedIndex
+}
+
+func (this *MemStore) GetServiceListWithIndex(service string) ([]*ServiceItem, uint64, error) {
+	this.rwlock.RLock()
+	defer this.rwlock.RUnlock()
+
+	return this.getServiceList(service), this.serviceIndexOf(service), nil
+}
+
+func (this *MemStore) WatchServiceList(ctx context.Context, service string, index uint64) ([]*ServiceItem, uint64, error) {
+	for {
+		this.rwlock.Lock()
+		if current := this.serviceIndexOf(service); current > index {
+			r := this.getServiceList(service)
+			this.rwlock.Unlock()
+			return r, current, nil
+		}
+		w, ok := this.serviceWatches[service]
+		if !ok {
+			w = &serviceWatch{ch: make(chan struct{})}
+			this.serviceWatches[service] = w
+		}
+		w.watchers++
+		this.rwlock.Unlock()
+
+		select {
+		case <-w.ch:
+		case <-ctx.Done():
+		}
+
+		this.rwlock.Lock()
+		w.watchers--
+		if w.watchers == 0 && this.serviceWatches[service] == w {
+			delete(this.serviceWatches, service)
+		}
+		this.rwlock.Unlock()
+
+		if ctx.Err() != nil {
+			return this.GetServiceListWithIndex(service)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemStoreWatchServiceRemoved(t *testing.T) {
+	store := NewMemStore("node1", nil)
+	item := &ServiceItem{Addr: "10.0.0.1:80", NodeId: "n1"}
+	if err := store.PublishService("svc", item); err != nil {
+		t.Fatal(err)
+	}
+	_, index, _ := store.GetServiceListWithIndex("svc")
+
+	type watchResult struct {
+		items []*ServiceItem
+		index uint64
+	}
+	result := make(chan watchResult, 1)
+	go func() {
+		items, index, _ := store.WatchServiceList(context.Background(), "svc", index)
+		result <- watchResult{items, index}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// changes of other services don't wake up the watch
+	if err := store.PublishService("other", item); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-result:
+		t.Fatal("watch should block until the service changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the index of the removed service is pruned and watchers still progress
+	if err := store.DeleteService("svc", item); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-result:
+		if len(r.items) != 0 || r.index <= index {
+			t.Fatal("unexpected watch result:", r.items, r.index)
+		}
+		index = r.index
+	case <-time.After(time.Second):
+		t.Fatal("watch should return once the service is removed")
+	}
+	if _, ok := store.serviceIndexes["svc"]; ok {
+		t.Fatal("index of the removed service should be pruned")
+	}
+	if _, current, _ := store.GetServiceListWithIndex("svc"); current != index {
+		t.Fatal("index of the removed service should be kept:", current, index)
+	}
+
+	// registering again increases the index
+	if err := store.PublishService("svc", item); err != nil {
+		t.Fatal(err)
+	}
+	if _, current, _ := store.GetServiceListWithIndex("svc"); current <= index {
+		t.Fatal("index should increase:", current, index)
+	}
+
+	// timed out watchers are cleaned
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, current, _ := store.GetServiceListWithIndex("unknown")
+	if _, _, err := store.WatchServiceList(ctx, "unknown", current); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.serviceWatches) != 0 {
+		t.Fatal("watches should be cleaned:", store.serviceWatches)
+	}
+}
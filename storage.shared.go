@@ -1,19 +1,137 @@
 package bootstrap
 
 import (
+	"context"
+	"slices"
+
 	"github.com/meidoworks/nekoq-bootstrap/internal/shared"
 )
 
+const DefaultServiceWeight = 1
+
+// health status of service instances
+const (
+	ServiceStatusPassing  = "passing"
+	ServiceStatusWarning  = "warning"
+	ServiceStatusCritical = "critical"
+)
+
+func IsValidServiceStatus(status string) bool {
+	switch status {
+	case ServiceStatusPassing, ServiceStatusWarning, ServiceStatusCritical:
+		return true
+	default:
+		return false
+	}
+}
+
 type ServiceItem struct {
-	Addr   string `json:"address"`
-	NodeId string `json:"node_id"`
+	Addr     string            `json:"address"`
+	NodeId   string            `json:"node_id"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Tags are free-form labels, e.g. zone=a or version=1.4
+	Tags     []string `json:"tags,omitempty"`
+	Weight   int      `json:"weight"`
+	Protocol string   `json:"protocol,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	// Status is the health reported by the instance or server-side checks
+	Status string `json:"status"`
+	// Maintenance drains the instance without deregistering it
+	Maintenance bool `json:"maintenance"`
+}
+
+// Passing reports whether the instance is healthy and not in maintenance
+func (i *ServiceItem) Passing() bool {
+	return i.Status == ServiceStatusPassing && !i.Maintenance
+}
+
+// HasTags reports whether the item has all the tags
+func (i *ServiceItem) HasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(i.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *ServiceItem) instance() serviceInstance {
+	return serviceInstance{
+		Addr:        i.Addr,
+		Metadata:    i.Metadata,
+		Tags:        i.Tags,
+		Weight:      i.Weight,
+		Protocol:    i.Protocol,
+		Port:        i.Port,
+		Status:      i.Status,
+		Maintenance: i.Maintenance,
+	}
+}
+
+// serviceInstance is the registration of a service on a node, stored and replicated to peers.
+// New fields must be optional to stay compatible with peers of previous versions.
+type serviceInstance struct {
+	Addr        string
+	Metadata    map[string]string `json:",omitempty"`
+	Tags        []string          `json:",omitempty"`
+	Weight      int               `json:",omitempty"`
+	Protocol    string            `json:",omitempty"`
+	Port        int               `json:",omitempty"`
+	Status      string            `json:",omitempty"`
+	Maintenance bool              `json:",omitempty"`
+}
+
+func (i serviceInstance) item(nodeId string) *ServiceItem {
+	weight := i.Weight
+	if weight == 0 {
+		// registered by peers of previous versions
+		weight = DefaultServiceWeight
+	}
+	status := i.Status
+	if status == "" {
+		status = ServiceStatusPassing
+	}
+	return &ServiceItem{
+		Addr:        i.Addr,
+		NodeId:      nodeId,
+		Metadata:    i.Metadata,
+		Tags:        i.Tags,
+		Weight:      weight,
+		Protocol:    i.Protocol,
+		Port:        i.Port,
+		Status:      status,
+		Maintenance: i.Maintenance,
+	}
+}
+
+// DomainRecord is a dns record put at runtime
+type DomainRecord struct {
+	Domain  string `json:"domain"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Origin  string `json:"origin"`
+	Version int64  `json:"version"`
 }
 
 type Storage interface {
 	ResolveDomain(domain string, domainType shared.DomainType) (string, error)
 	PutDomain(domain, resolve string, domainType shared.DomainType)
+	// GetDomain returns the record put at runtime, static rules and nested stores are excluded
+	GetDomain(domain string, domainType shared.DomainType) (*DomainRecord, error)
+	// ListDomains returns all the records put at runtime ordered by domain and type
+	ListDomains() ([]*DomainRecord, error)
+	// DeleteDomain removes the record put at runtime. ErrStorageNotFound is returned if it doesn't exist.
+	DeleteDomain(domain string, domainType shared.DomainType) error
+	// ReplaceStaticRules replaces all the static rules loaded from configuration
+	ReplaceStaticRules(rules map[shared.DomainType]map[string]string)
 
 	GetServiceList(service string) ([]*ServiceItem, error)
+	// GetServiceListWithIndex returns the service list together with its modification index.
+	// The index of a service without instances is the index of the latest removal of a service, 0 if none.
+	GetServiceListWithIndex(service string) ([]*ServiceItem, uint64, error)
+	// WatchServiceList blocks until the modification index of the service is greater than index.
+	// The current list is returned when ctx is done.
+	WatchServiceList(ctx context.Context, service string, index uint64) ([]*ServiceItem, uint64, error)
 	PublishService(service string, item *ServiceItem) error
 	DeleteService(service string, item *ServiceItem) error
 